@@ -6,6 +6,7 @@ require (
 	github.com/jackc/pgx/v4 v4.16.1
 	github.com/joho/godotenv v1.4.0
 	github.com/mitchellh/go-ps v1.0.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
+	"zehd-backend/internal/certs"
+	"zehd-backend/internal/config"
+	"zehd-backend/internal/handlers"
+	"zehd-backend/internal/internaldb"
+	"zehd-backend/internal/logging"
+	"zehd-backend/internal/metrics"
+	"zehd-backend/internal/middleware"
+
+	. "zehd-backend/internal"
+)
+
+// Main Load the configuration and either run the command given in args or serve the API until SIGINT or SIGTERM, returning the exit
+// code: 0 after a clean shutdown, 1 when serving, shutting down or the command failed, 2 for invalid configuration or usage
+func Main(args []string) int {
+	loaded, args, err := config.Load(args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Println("Usage: zehd-backend [flags] [migrate [up|down [steps]|status] | frontend [add|rotate|remove <name>|list] | backfill-rollups | prune [--dry-run]]")
+		config.Usage(os.Stdout)
+		return 0
+	}
+	if err != nil {
+		fmt.Println("Invalid configuration:")
+		fmt.Println(err)
+		return 2
+	}
+	Conf = loaded
+	logging.ConfigureProfiler(Conf.Profiler.Enabled, Conf.Profiler.Samples)
+	err = logging.Configure(Conf.Log)
+	if err != nil {
+		fmt.Println("Unable to set up logging:")
+		fmt.Println(err)
+		return 1
+	}
+	defer logging.Close()
+	if len(args) > 0 {
+		return runCommand(args)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return serve(ctx, stop)
+}
+
+// serve starts the background jobs and serves the API until ctx is cancelled, then drains in-flight requests, stops the jobs and closes
+// the database pool. Collected data is written before each request is answered, so draining requests leaves no writes behind
+func serve(ctx context.Context, stop context.CancelFunc) int {
+	// requests are answered with 503 until the database is reached, retrying in the background
+	internaldb.Go(func() {
+		fmt.Printf("Initializing DB... ")
+		errConnect := internaldb.Connect(ctx)
+		if errConnect != nil {
+			fmt.Println("Failed.")
+			if ctx.Err() == nil {
+				logging.LogIt("main", "ERROR", "unable to initialize database on startup. please review the logs for more details")
+			}
+			return
+		}
+		fmt.Printf("Done.\n")
+		internaldb.StartMonitor(ctx)
+		startJobs(ctx)
+	})
+
+	accessLog, accessLogFile, errAccessLog := openAccessLog()
+	if errAccessLog != nil {
+		fmt.Println("Unable to open access log:")
+		fmt.Println(errAccessLog)
+		return 1
+	}
+	if accessLogFile != nil {
+		defer accessLogFile.Close()
+	}
+	server := &http.Server{Addr: Conf.Listen, Handler: routes(accessLog)}
+	if Conf.TLS.CertFile != "" {
+		reloader, errCerts := certs.NewReloader(Conf.TLS.CertFile, Conf.TLS.KeyFile, Conf.TLS.ClientCA)
+		if errCerts != nil {
+			fmt.Println(errCerts)
+			return 1
+		}
+		reloader.Start(ctx, Conf.TLS.ReloadInterval)
+		server.TLSConfig = reloader.TLSConfig()
+	}
+	var profiler *http.Server
+	if Conf.Profiler.Enabled {
+		profiler = &http.Server{Addr: Conf.Profiler.Listen, Handler: profilerRoutes()}
+		go func() {
+			fmt.Printf("Profiler listening on %s.\n", Conf.Profiler.Listen)
+			errProfiler := profiler.ListenAndServe()
+			if !errors.Is(errProfiler, http.ErrServerClosed) {
+				logging.LogIt("main", "ERROR", "unable to serve profiler: "+fmt.Sprintln(errProfiler))
+			}
+		}()
+	}
+	served := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			fmt.Printf("Listening on %s (HTTPS).\n", Conf.Listen)
+			served <- server.ListenAndServeTLS("", "")
+		} else {
+			fmt.Printf("Listening on %s.\n", Conf.Listen)
+			served <- server.ListenAndServe()
+		}
+	}()
+
+	exitCode := 0
+	var err error
+	select {
+	case err = <-served:
+		logging.LogIt("main", "ERROR", "unable to serve: "+fmt.Sprintln(err))
+		exitCode = 1
+	case <-ctx.Done():
+		logging.LogIt("main", "INFO", "shutting down, draining in-flight requests")
+	}
+	// a second signal kills the process, should shutting down take too long
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), Conf.ShutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		logging.LogIt("main", "ERROR", "unable to drain in-flight requests: "+fmt.Sprintln(err))
+		exitCode = 1
+	}
+	if profiler != nil {
+		_ = profiler.Shutdown(shutdownCtx)
+	}
+	err = internaldb.Close(shutdownCtx)
+	if err != nil {
+		logging.LogIt("main", "ERROR", "unable to close database: "+fmt.Sprintln(err))
+		exitCode = 1
+	}
+	fmt.Println("===============================================================================================")
+	return exitCode
+}
+
+// profilerRoutes registers net/http/pprof, expvar and the latest TrackTime measurements, served apart from the API so they are never
+// exposed to frontends. /status and /metrics are served here too, without the API's guards, for local scrapers
+func profilerRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", handlers.StatusHandler)
+	mux.HandleFunc("/metrics", metrics.Handler)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/tracktime", handlers.TrackTimeHandler)
+	return mux
+}
+
+// openAccessLog opens the access log configured in Conf.AccessLog, nil if none is. A file is rotated like the log file, and returned
+// to be closed on shutdown
+func openAccessLog() (*middleware.AccessLog, io.Closer, error) {
+	if Conf.AccessLog.Format == "" {
+		return nil, nil, nil
+	}
+	if Conf.AccessLog.File == "-" {
+		return middleware.NewAccessLog(os.Stdout, Conf.AccessLog.Format, Conf.AccessLog.CollectSample), nil, nil
+	}
+	rotation := Conf.Log
+	rotation.File = Conf.AccessLog.File
+	file, err := logging.OpenRotating(rotation)
+	if err != nil {
+		return nil, nil, err
+	}
+	return middleware.NewAccessLog(file, Conf.AccessLog.Format, Conf.AccessLog.CollectSample), file, nil
+}
+
+// startJobs starts the enabled background jobs, which stop once ctx is cancelled
+func startJobs(ctx context.Context) {
+	if Conf.Bans.Sweeper {
+		internaldb.StartBanSweeper(ctx, Conf.Bans.SweepInterval)
+	}
+	if Conf.Bans.Cache {
+		errCache := internaldb.StartBanCache(ctx, Conf.Bans.CachePoll)
+		if errCache != nil {
+			logging.LogIt("main", "ERROR", "unable to load ban cache, checking bans against the database instead")
+		}
+	}
+	if Conf.Rules.File != "" {
+		startRulesEngine(ctx, Conf.Rules.File)
+	}
+	if Conf.Rollups.Enabled {
+		internaldb.StartRollups(ctx, Conf.Rollups.Interval)
+	}
+	if policy := retentionPolicy(); policy.Enabled() {
+		internaldb.StartRetention(ctx, policy, Conf.Retention.Interval)
+	}
+}
+
+// routes registers every endpoint. Frontends must present a client certificate signed by the client CA and/or sign their requests to
+// send or read data, heartbeats, check bans or read the status and metrics, when configured to, and only the frontends listed in
+// auth.admins may then ban IPs or lift bans. Only the /healthz and /readyz probes are always public. Endpoints needing the database
+// answer 503 while it is unavailable
+func routes(accessLog *middleware.AccessLog) *http.ServeMux {
+	database := middleware.RequireDatabase(internaldb.Available)
+	var guards []func(http.HandlerFunc) http.HandlerFunc
+	if Conf.TLS.ClientCA != "" {
+		guards = append(guards, middleware.RequireClientCert)
+	}
+	if Conf.Auth.Required {
+		guards = append(guards, middleware.RequireSignature(internaldb.FrontendKey, Conf.Auth.MaxAge))
+	}
+	guarded := func(handler http.HandlerFunc) http.HandlerFunc {
+		for i := len(guards) - 1; i >= 0; i-- {
+			handler = guards[i](handler)
+		}
+		return handler
+	}
+	protected := func(handler http.HandlerFunc) http.HandlerFunc {
+		return database(guarded(handler))
+	}
+	// banning and lifting bans is left to the admins once frontends are authenticated, while any frontend may check for bans
+	admin := func(handler http.HandlerFunc) http.HandlerFunc { return handler }
+	if len(guards) > 0 {
+		admin = middleware.RequireAdmin(Conf.Auth.AdminNames())
+	}
+	mux := http.NewServeMux()
+	// every request is given an ID for its log lines, written to the access log, and counted and timed under the pattern it was
+	// routed by
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, middleware.AssignRequestID(accessLog.Handler(metrics.Instrument(pattern, handler))))
+	}
+	handle("/healthz", handlers.HealthzHandler)
+	handle("/readyz", handlers.ReadyzHandler)
+	handle("/status", guarded(handlers.StatusHandler))
+	handle("/metrics", guarded(metrics.Handler))
+	handle("/database/exist", protected(handlers.ExistHandler))
+	handle("/api/collect", protected(handlers.CollectHandler))
+	handle("/api/collect/batch", protected(handlers.CollectBatchHandler))
+	handle("/api/banned", protected(admin(handlers.BannedHandler)))
+	handle("/api/collected", protected(handlers.CollectedHandler))
+	handle("/api/stats/", protected(handlers.StatsHandler))
+	handle("/api/frontends", protected(handlers.FrontendsHandler))
+	handle("/api/frontends/", protected(handlers.FrontendsHandler))
+	return mux
+}
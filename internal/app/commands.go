@@ -0,0 +1,190 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+	"zehd-backend/internal/internaldb"
+	"zehd-backend/internal/logging"
+	"zehd-backend/internal/rules"
+
+	. "zehd-backend/internal"
+)
+
+// runCommand runs a one-off command instead of serving, returning the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "frontend":
+		return frontendCommand(args[1:])
+	case "backfill-rollups":
+		if _, err := internaldb.InitDB(context.Background()); err != nil {
+			fmt.Println("Unable to rebuild rollup tables without a database.")
+			return 1
+		}
+		fmt.Printf("Rebuilding rollup tables... ")
+		rolledUp, err := internaldb.BackfillRollups(context.Background())
+		if err != nil {
+			fmt.Println("Failed.")
+			fmt.Println(err)
+			return 1
+		}
+		fmt.Printf("Done, %d collected rows rolled up.\n", rolledUp)
+		return 0
+	case "prune":
+		if _, err := internaldb.InitDB(context.Background()); err != nil {
+			fmt.Println("Unable to prune without a database.")
+			return 1
+		}
+		policy := retentionPolicy()
+		if !policy.Enabled() {
+			fmt.Println("Nothing to prune, set retention.raw and/or retention.rollups.")
+			return 1
+		}
+		policy.DryRun = policy.DryRun || (len(args) > 1 && args[1] == "--dry-run")
+		reports, err := internaldb.ApplyRetention(context.Background(), policy)
+		for _, report := range reports {
+			fmt.Println(report)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		return 0
+	default:
+		fmt.Println("Unknown command: " + args[0])
+		fmt.Println("Available commands: migrate [up|down [steps]|status], frontend [add|rotate|remove <name>|list], backfill-rollups, prune [--dry-run]")
+		return 2
+	}
+}
+
+// migrateCommand applies (up, the default) or reverts (down, one migration unless told how many) migrations, or lists them (status)
+func migrateCommand(args []string) int {
+	if _, err := internaldb.ConnectDB(); err != nil {
+		fmt.Println("Unable to migrate without a database.")
+		return 1
+	}
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "up":
+		applied, err := internaldb.MigrateUp(context.Background())
+		fmt.Printf("%d migrations applied.\n", applied)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Println("Steps must be a positive number.")
+				return 2
+			}
+		}
+		reverted, err := internaldb.MigrateDown(context.Background(), steps)
+		fmt.Printf("%d migrations reverted.\n", reverted)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	case "status":
+		statuses, err := internaldb.MigrationStatuses()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + time.Unix(status.AppliedAt, 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-30s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		fmt.Println("Unknown migrate action: " + action)
+		return 2
+	}
+	return 0
+}
+
+// frontendCommand registers (add) or removes frontends, replaces their API key (rotate) or lists them (list)
+func frontendCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "list" && len(args) != 2) {
+		fmt.Println("Usage: frontend [add|rotate|remove <name>|list]")
+		return 2
+	}
+	if _, err := internaldb.InitDB(context.Background()); err != nil {
+		fmt.Println("Unable to manage frontends without a database.")
+		return 1
+	}
+	var err error
+	switch args[0] {
+	case "add", "rotate":
+		var key string
+		if args[0] == "add" {
+			key, err = internaldb.AddFrontend(args[1])
+		} else {
+			key, err = internaldb.RotateFrontendKey(args[1])
+		}
+		if err == nil {
+			fmt.Println("API key for " + args[1] + ", it will not be shown again:")
+			fmt.Println(key)
+		}
+	case "remove":
+		err = internaldb.RemoveFrontend(args[1])
+	case "list":
+		var frontends []internaldb.Frontend
+		frontends, err = internaldb.ListFrontends(context.Background(), DefaultFrontendSilentAfter)
+		for _, frontend := range frontends {
+			lastSeen := "never seen"
+			if frontend.LastSeen > 0 {
+				lastSeen = "last seen " + time.Unix(frontend.LastSeen, 0).UTC().Format(time.RFC3339)
+			}
+			key := "no API key"
+			if frontend.HasKey {
+				key = "API key set"
+			}
+			fmt.Printf("%-30s %-12s %-36s %s\n", frontend.Name, key, lastSeen, frontend.Version)
+		}
+	default:
+		fmt.Println("Unknown frontend action: " + args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// retentionPolicy builds the retention policy from the loaded configuration
+func retentionPolicy() internaldb.RetentionPolicy {
+	return internaldb.RetentionPolicy{
+		Raw:        Conf.Retention.Raw,
+		Rollups:    Conf.Retention.Rollups,
+		ArchiveDir: Conf.Retention.ArchiveDir,
+		DryRun:     Conf.Retention.DryRun,
+	}
+}
+
+// startRulesEngine loads the rules configuration and starts evaluating collected traffic in the background, until ctx is cancelled
+func startRulesEngine(ctx context.Context, path string) {
+	config, err := rules.LoadConfig(path)
+	if err != nil {
+		logging.LogIt("main", "ERROR", "unable to load rules configuration: "+fmt.Sprintln(err))
+		return
+	}
+	engine, err := rules.New(config)
+	if err != nil {
+		logging.LogIt("main", "ERROR", "invalid rules configuration: "+fmt.Sprintln(err))
+		return
+	}
+	engine.Start(ctx)
+}
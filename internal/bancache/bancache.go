@@ -0,0 +1,123 @@
+package bancache
+
+import (
+	"net/netip"
+	"sync/atomic"
+)
+
+// node a single bit of an address within the tree. Values are stored on the node where their prefix ends
+type node[V any] struct {
+	children [2]*node[V]
+	values   []V
+}
+
+// Tree Binary radix tree of network prefixes, with separate roots for IPv4 and IPv6. It is not safe for concurrent writes,
+// so build it fully before handing it to a Cache
+type Tree[V any] struct {
+	v4   node[V]
+	v6   node[V]
+	size int
+}
+
+// NewTree Create an empty tree
+func NewTree[V any]() *Tree[V] {
+	return &Tree[V]{}
+}
+
+// Insert Add a value for the prefix. Prefixes are masked and IPv4-mapped IPv6 addresses are stored as IPv4
+func (tree *Tree[V]) Insert(prefix netip.Prefix, value V) {
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr = addr.Unmap()
+		bits -= 96
+	}
+	current := tree.root(addr)
+	for i := 0; i < bits; i++ {
+		bit := bitAt(addr, i)
+		if current.children[bit] == nil {
+			current.children[bit] = &node[V]{}
+		}
+		current = current.children[bit]
+	}
+	current.values = append(current.values, value)
+	tree.size++
+}
+
+// Lookup Find the value of the most specific prefix containing the address, for which match returns true.
+// When several values share that prefix the one inserted last wins. A nil match accepts every value
+func (tree *Tree[V]) Lookup(addr netip.Addr, match func(V) bool) (V, bool) {
+	var found V
+	var ok bool
+	addr = addr.Unmap()
+	current := tree.root(addr)
+	for i := 0; current != nil; i++ {
+		for v := len(current.values) - 1; v >= 0; v-- {
+			if match == nil || match(current.values[v]) {
+				found, ok = current.values[v], true
+				break
+			}
+		}
+		if i == addr.BitLen() {
+			break
+		}
+		current = current.children[bitAt(addr, i)]
+	}
+	return found, ok
+}
+
+// Len The number of values in the tree
+func (tree *Tree[V]) Len() int {
+	return tree.size
+}
+
+func (tree *Tree[V]) root(addr netip.Addr) *node[V] {
+	if addr.Is4() {
+		return &tree.v4
+	}
+	return &tree.v6
+}
+
+// bitAt returns the i-th most significant bit of the address
+func bitAt(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		b := addr.As4()
+		return int(b[i/8]>>(7-i%8)) & 1
+	}
+	b := addr.As16()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// Cache Holds the current tree, which is swapped out as a whole whenever it is rebuilt, so lookups never wait on a reload
+type Cache[V any] struct {
+	tree atomic.Pointer[Tree[V]]
+}
+
+// Replace Swap in a freshly built tree
+func (cache *Cache[V]) Replace(tree *Tree[V]) {
+	cache.tree.Store(tree)
+}
+
+// Ready Reports whether a tree has been loaded yet
+func (cache *Cache[V]) Ready() bool {
+	return cache.tree.Load() != nil
+}
+
+// Lookup Same as Tree.Lookup, on the current tree. It always misses until the cache is Ready
+func (cache *Cache[V]) Lookup(addr netip.Addr, match func(V) bool) (V, bool) {
+	tree := cache.tree.Load()
+	if tree == nil {
+		var empty V
+		return empty, false
+	}
+	return tree.Lookup(addr, match)
+}
+
+// Len The number of values in the current tree
+func (cache *Cache[V]) Len() int {
+	tree := cache.tree.Load()
+	if tree == nil {
+		return 0
+	}
+	return tree.Len()
+}
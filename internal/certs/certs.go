@@ -0,0 +1,121 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zehd-backend/internal/logging"
+)
+
+// Reloader Serves a certificate, and optionally a CA client certificates are verified against, reloading them whenever their files change
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+}
+
+// NewReloader Load the certificate, key and client CA (none if empty), failing if any of them cannot be loaded
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	reloader := &Reloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile, modTimes: make(map[string]time.Time)}
+	_, err := reloader.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Reload Load the files again if any of them changed since the last load. The previous certificates are kept on failure
+func (reloader *Reloader) Reload() (bool, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	changed := false
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{reloader.certFile, reloader.keyFile, reloader.clientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes[file] = info.ModTime()
+		changed = changed || !info.ModTime().Equal(reloader.modTimes[file])
+	}
+	if !changed {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to load certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if reloader.clientCAFile != "" {
+		pem, err := os.ReadFile(reloader.clientCAFile)
+		if err != nil {
+			return false, fmt.Errorf("unable to read client CA: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return false, errors.New("no certificates found in client CA " + reloader.clientCAFile)
+		}
+	}
+	reloader.cert.Store(&cert)
+	reloader.clientCAs.Store(clientCAs)
+	reloader.modTimes = modTimes
+	return true, nil
+}
+
+// Start Check for changed files every interval, until ctx is cancelled
+func (reloader *Reloader) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reloaded, err := reloader.Reload()
+				if err != nil {
+					logging.LogIt("certs.Start", "ERROR", "unable to reload certificates, keeping the current ones: "+fmt.Sprintln(err))
+					continue
+				}
+				if reloaded {
+					logging.LogIt("certs.Start", "INFO", "certificates reloaded")
+				}
+			}
+		}
+	}()
+}
+
+// TLSConfig Server TLS configuration using the current certificates. With a client CA, client certificates are verified when presented;
+// requiring them is left to the handlers, so only some endpoints need one
+func (reloader *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"h2", "http/1.1"},
+				Certificates: []tls.Certificate{*reloader.cert.Load()},
+			}
+			if clientCAs := reloader.clientCAs.Load(); clientCAs != nil {
+				config.ClientCAs = clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return config, nil
+		},
+	}
+}
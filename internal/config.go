@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config Backend configuration, loaded by config.Load from defaults, an optional YAML file, the environment and flags, in that order
+type Config struct {
+	// Listen the address the API is served on, e.g. ":8080"
+	Listen    string          `yaml:"listen"`
+	DB        DBConfig        `yaml:"db"`
+	TLS       TLSConfig       `yaml:"tls"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Log       LogConfig       `yaml:"log"`
+	AccessLog AccessLogConfig `yaml:"accessLog"`
+	Bans      BansConfig      `yaml:"bans"`
+	Rules     RulesConfig     `yaml:"rules"`
+	Rollups   RollupsConfig   `yaml:"rollups"`
+	Retention RetentionConfig `yaml:"retention"`
+	Profiler  ProfilerConfig  `yaml:"profiler"`
+
+	// ShutdownTimeout how long in-flight requests and background jobs are given to finish on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// DBConfig Postgres connection settings. URL, when set, replaces Host to Name; the SSL settings apply either way
+type DBConfig struct {
+	URL      string `yaml:"url"`
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	// SSLMode one of disable, allow, prefer, require, verify-ca or verify-full. Defaults to disable, or to what URL says
+	SSLMode     string `yaml:"sslMode"`
+	SSLRootCert string `yaml:"sslRootCert"`
+	SSLCert     string `yaml:"sslCert"`
+	SSLKey      string `yaml:"sslKey"`
+
+	// pool settings, see sql.DB. Zero means unlimited, except for MaxIdleConns
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime"`
+	// RetryMin and RetryMax bound the exponential backoff between connection attempts, on startup and after the connection is lost
+	RetryMin time.Duration `yaml:"retryMin"`
+	RetryMax time.Duration `yaml:"retryMax"`
+	// CheckInterval how often the connection is checked while it is up
+	CheckInterval time.Duration `yaml:"checkInterval"`
+}
+
+// SSLModes the sslmode values Postgres clients accept
+var SSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// ConnString Build the connection string every database connection is opened with
+func (c DBConfig) ConnString() (string, error) {
+	ssl := [][2]string{{"sslmode", c.SSLMode}, {"sslrootcert", c.SSLRootCert}, {"sslcert", c.SSLCert}, {"sslkey", c.SSLKey}}
+	if c.URL != "" {
+		parsed, err := url.Parse(c.URL)
+		if err != nil {
+			return "", err
+		}
+		query := parsed.Query()
+		for _, param := range ssl {
+			if param[1] != "" {
+				query.Set(param[0], param[1])
+			}
+		}
+		parsed.RawQuery = query.Encode()
+		return parsed.String(), nil
+	}
+	if ssl[0][1] == "" {
+		ssl[0][1] = "disable"
+	}
+	params := [][2]string{{"host", c.Host}, {"port", c.Port}, {"user", c.User}, {"password", c.Password}, {"database", c.Name}}
+	var connString []string
+	for _, param := range append(params, ssl...) {
+		if param[1] != "" {
+			connString = append(connString, param[0]+"="+quoteConnValue(param[1]))
+		}
+	}
+	return strings.Join(connString, " "), nil
+}
+
+// quoteConnValue quotes a keyword/value connection string value, so spaces and quotes in passwords or paths survive
+func quoteConnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// TLSConfig Certificate and key the API is served with. The API is served in plaintext when both are empty. With ClientCA set, frontends
+// must present a client certificate signed by it to collect data and manage bans, and its common name is recorded as the frontend name
+type TLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	ClientCA string `yaml:"clientCA"`
+	// ReloadInterval how often the files are checked for changes, reloading them without a restart
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+// AuthConfig With Required set, frontends must sign requests to collect data and manage bans with their API key, see middleware.RequireSignature
+type AuthConfig struct {
+	Required bool `yaml:"required"`
+	// MaxAge how far the timestamp of a signed request may be from the backend's clock
+	MaxAge time.Duration `yaml:"maxAge"`
+	// Admins a comma separated list of the frontends allowed to ban IPs and lift bans, see middleware.RequireAdmin
+	Admins string `yaml:"admins"`
+}
+
+// AdminNames The frontends allowed to ban IPs and lift bans, none if Admins is empty
+func (conf AuthConfig) AdminNames() []string {
+	var admins []string
+	for _, admin := range strings.Split(conf.Admins, ",") {
+		admin = strings.TrimSpace(admin)
+		if admin != "" {
+			admins = append(admins, admin)
+		}
+	}
+	return admins
+}
+
+// LogConfig Where logs are written, how they are formatted and rotated, and the least severe level written
+type LogConfig struct {
+	Level string `yaml:"level"`
+	File  string `yaml:"file"`
+	// Format text for the classic "function [ LEVEL ] ==> message" lines, json or logfmt
+	Format string `yaml:"format"`
+	// Output where lines are written, a comma separated list of file, stdout, journald and syslog. both stands for file and stdout
+	Output string `yaml:"output"`
+	// MaxSize the size in megabytes the log file may reach before it is rotated, never if 0
+	MaxSize int `yaml:"maxSize"`
+	// MaxAge how long the log file is written to before it is rotated, never if 0
+	MaxAge time.Duration `yaml:"maxAge"`
+	// MaxBackups how many rotated files are kept, all of them if 0
+	MaxBackups int `yaml:"maxBackups"`
+	// Compress gzip rotated files
+	Compress bool `yaml:"compress"`
+
+	Syslog SyslogConfig `yaml:"syslog"`
+}
+
+// SyslogConfig Where and how RFC 5424 syslog messages are sent, when log.output includes syslog
+type SyslogConfig struct {
+	// Address unix:///dev/log for the local syslog daemon, or udp://host:514
+	Address  string `yaml:"address"`
+	Facility string `yaml:"facility"`
+	// Tag the APP-NAME of the messages, and journald's SYSLOG_IDENTIFIER
+	Tag string `yaml:"tag"`
+	// EnterpriseID the IANA private enterprise number fields are sent under as structured data, fields@<EnterpriseID>. Without
+	// one, fields are appended to the message as key=value
+	EnterpriseID string `yaml:"enterpriseID"`
+}
+
+// Outputs Where lines are written, with both expanded to file and stdout, which is also the default
+func (conf LogConfig) Outputs() []string {
+	var outputs []string
+	for _, output := range strings.Split(conf.Output, ",") {
+		output = strings.TrimSpace(output)
+		switch output {
+		case "":
+		case "both":
+			outputs = append(outputs, "file", "stdout")
+		default:
+			outputs = append(outputs, output)
+		}
+	}
+	if len(outputs) == 0 {
+		return []string{"file", "stdout"}
+	}
+	return outputs
+}
+
+// AccessLogConfig With Format set, a line is written to File for every request answered
+type AccessLogConfig struct {
+	// Format common or combined for the Common or Combined Log Format, or json. No access log is written when empty
+	Format string `yaml:"format"`
+	// File the file access logs are written to, rotated like the log file, or "-" for stdout
+	File string `yaml:"file"`
+	// CollectSample the share of successful /api/collect and /api/collect/batch requests logged, from 0 to 1. Failed ones are always logged
+	CollectSample float64 `yaml:"collectSample"`
+}
+
+// AccessLogFormats the formats access logs can be written in
+var AccessLogFormats = []string{"common", "combined", "json"}
+
+// LogFormats the formats log lines can be written in
+var LogFormats = []string{"text", "json", "logfmt"}
+
+// LogOutputs where log lines can be written
+var LogOutputs = []string{"file", "stdout", "both", "journald", "syslog"}
+
+// SyslogFacilities syslog facility names, in the order of their codes
+var SyslogFacilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp",
+	"ntp", "security", "console", "solaris-cron", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+// BansConfig Ban cache and expired ban sweeper toggles
+type BansConfig struct {
+	Cache         bool          `yaml:"cache"`
+	CachePoll     time.Duration `yaml:"cachePoll"`
+	Sweeper       bool          `yaml:"sweeper"`
+	SweepInterval time.Duration `yaml:"sweepInterval"`
+}
+
+// RulesConfig The rules engine is enabled when File is set
+type RulesConfig struct {
+	File string `yaml:"file"`
+}
+
+// RollupsConfig Rollup table job toggle
+type RollupsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// ProfilerConfig With Enabled, TrackTime measurements are printed and kept, and net/http/pprof, expvar and the latest measurements are
+// served on Listen, apart from the API
+type ProfilerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Listen the admin address, which should not be reachable by frontends or the public
+	Listen string `yaml:"listen"`
+	// Samples how many of the latest measurements are kept per task
+	Samples int `yaml:"samples"`
+}
+
+// RetentionConfig Retention policy, see internaldb.RetentionPolicy. Nothing is pruned when Raw and Rollups are zero
+type RetentionConfig struct {
+	Raw        time.Duration `yaml:"raw"`
+	Rollups    time.Duration `yaml:"rollups"`
+	ArchiveDir string        `yaml:"archiveDir"`
+	DryRun     bool          `yaml:"dryRun"`
+	Interval   time.Duration `yaml:"interval"`
+}
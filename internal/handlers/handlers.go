@@ -1,13 +1,24 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 	"zehd-backend/internal/helper"
 	"zehd-backend/internal/internaldb"
 	"zehd-backend/internal/logging"
+	"zehd-backend/internal/middleware"
 
 	. "zehd-backend/internal"
 )
@@ -20,21 +31,21 @@ func ExistHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	switch r.Method {
 	case GET:
-		logging.LogIt("existHandler", "INFO", "get request received, for checking if the database exists")
-		processNotFound := internaldb.CheckDB()
+		logging.LogCtx(r.Context(), "INFO", "get request received, for checking if the database exists")
+		processNotFound := internaldb.CheckDB(r.Context())
 		if processNotFound != "exists" {
 			w.WriteHeader(500)
 			w.Header().Set("Content-Type", "application/text")
 			_, writeErr := w.Write([]byte(processNotFound))
 			if writeErr != nil {
-				logging.LogIt("existHandler", "ERROR", "error writing response")
+				logging.LogCtx(r.Context(), "ERROR", "error writing response")
 			}
 		} else {
 			w.WriteHeader(200)
 			w.Header().Set("Content-Type", "application/text")
 			_, writeErr := w.Write([]byte("exists"))
 			if writeErr != nil {
-				logging.LogIt("existHandler", "ERROR", "error writing response")
+				logging.LogCtx(r.Context(), "ERROR", "error writing response")
 			}
 		}
 	case POST:
@@ -43,18 +54,30 @@ func ExistHandler(w http.ResponseWriter, r *http.Request) {
 			errJson := json.NewDecoder(r.Body).Decode(&dbExists)
 			if errJson != nil {
 				helper.ErrorResponse(w, "Bad Request: Wrong Content-Type provided", http.StatusBadRequest)
-				logging.LogIt("existHandler", "ERROR", "error decoding json request")
+				logging.LogCtx(r.Context(), "ERROR", "error decoding json request")
+				return
+			}
+			if frontend := middleware.Frontend(r); frontend != "" {
+				dbExists.Frontend = frontend
+			} else if dbExists.Frontend != "" {
+				r = r.WithContext(logging.WithFields(r.Context(), "frontend", dbExists.Frontend))
+			}
+			logging.LogCtx(r.Context(), "INFO", dbExists.Frontend+" has "+dbExists.Connection+" as its connection/database status")
+			if dbExists.Frontend != "" && Db != nil {
+				errHeartbeat := internaldb.RecordHeartbeat(r.Context(), dbExists.Frontend, dbExists.Version, dbExists.Connection)
+				if errHeartbeat != nil {
+					logging.LogCtx(r.Context(), "ERROR", "unable to record heartbeat of "+dbExists.Frontend+": "+fmt.Sprintln(errHeartbeat))
+				}
 			}
-			logging.LogIt("existHandler", "INFO", dbExists.Frontend+" has "+dbExists.Connection+" as its connection/database status")
 			if dbExists.Tables == "create" {
-				processStatus, errInit := internaldb.InitDB()
+				processStatus, errInit := internaldb.InitDB(r.Context())
 				if errInit != nil {
-					logging.LogIt("existHandler", "ERROR", "unable to initialize db. please review the logs for more details")
+					logging.LogCtx(r.Context(), "ERROR", "unable to initialize db. please review the logs for more details")
 					w.WriteHeader(500)
 					w.Header().Set("Content-Type", "application/text")
 					_, writeErr := w.Write([]byte(dbExists.Tables))
 					if writeErr != nil {
-						logging.LogIt("existHandler", "INFO", "init failed: "+fmt.Sprintln(errInit))
+						logging.LogCtx(r.Context(), "INFO", "init failed: "+fmt.Sprintln(errInit))
 					}
 				}
 				dbExists.Tables = processStatus
@@ -62,7 +85,7 @@ func ExistHandler(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Type", "application/text")
 				_, writeErr := w.Write([]byte(dbExists.Tables))
 				if writeErr != nil {
-					logging.LogIt("existHandler", "INFO", "init completed")
+					logging.LogCtx(r.Context(), "INFO", "init completed")
 				}
 			}
 		} else {
@@ -87,7 +110,7 @@ func CollectHandler(w http.ResponseWriter, r *http.Request) {
 		headerContentType := r.Header.Get("Content-Type")
 		if headerContentType != "application/json" {
 			helper.ErrorResponse(w, "Content Type is not application/json", http.StatusUnsupportedMediaType)
-			logging.LogIt("collectHandler", "WARNING", "invalid 'Content-Type' received")
+			logging.LogCtx(r.Context(), "WARNING", "invalid 'Content-Type' received")
 			return
 		}
 		var unmarshalErr *json.UnmarshalTypeError
@@ -97,105 +120,532 @@ func CollectHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			if errors.As(err, &unmarshalErr) {
 				helper.ErrorResponse(w, "Bad Request: Wrong Type provided for field: "+unmarshalErr.Field, http.StatusBadRequest)
-				logging.LogIt("collectHandler", "WARNING", "Bad Request: Wrong Type provided for field: "+unmarshalErr.Field)
+				logging.LogCtx(r.Context(), "WARNING", "Bad Request: Wrong Type provided for field: "+unmarshalErr.Field)
 			} else {
 				helper.ErrorResponse(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
-				logging.LogIt("collectHandler", "WARNING", "Bad Request: "+fmt.Sprintln(err))
+				logging.LogCtx(r.Context(), "WARNING", "Bad Request: "+fmt.Sprintln(err))
 			}
 			return
 		}
-		helper.ErrorResponse(w, "Exists", http.StatusOK)
-		err = collectionData.InsertCollectedData()
+		if frontend := middleware.Frontend(r); frontend != "" {
+			collectionData.FrontendName = frontend
+		} else {
+			r = r.WithContext(logging.WithFields(r.Context(), "frontend", collectionData.FrontendName))
+		}
+		err = collectionData.InsertCollectedData(r.Context())
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			logging.LogIt("collectHandler", "ERROR", "error inserting data into database: "+fmt.Sprintln(err))
+			logging.LogCtx(r.Context(), "ERROR", "error inserting data into database: "+fmt.Sprintln(err))
+			// the database may have gone away since the request was let through, frontends retry those later
+			if !internaldb.Available() {
+				w.Header().Set("Retry-After", "5")
+				helper.ErrorResponse(w, "Service Unavailable: database unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			helper.ErrorResponse(w, "Internal Server Error: unable to store collected data", http.StatusInternalServerError)
+			return
 		}
+		helper.ErrorResponse(w, "Exists", http.StatusOK)
 	default:
 		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
-		logging.LogIt("collectHandler", "WARNING", "received invalid method")
+		logging.LogCtx(r.Context(), "WARNING", "received invalid method")
+	}
+}
+
+// CollectBatchHandler Endpoint for collecting batches of data from frontends via POST methods. It takes either a JSON array or an NDJSON stream
+func CollectBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/collect/batch" {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+	if r.Method != POST {
+		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
+		logging.LogCtx(r.Context(), "WARNING", "received invalid method")
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var batch []internaldb.CollectionData
+	var results []internaldb.BatchRecordResult
+	var err error
+	body := http.MaxBytesReader(w, r.Body, MaxBatchBytes)
+	switch mediaType {
+	case "application/json":
+		batch, results, err = decodeJSONBatch(body)
+	case "application/x-ndjson":
+		batch, results, err = decodeNDJSONBatch(body)
+	default:
+		helper.ErrorResponse(w, "Content Type is not application/json or application/x-ndjson", http.StatusUnsupportedMediaType)
+		logging.LogCtx(r.Context(), "WARNING", "invalid 'Content-Type' received")
+		return
+	}
+	if err != nil {
+		helper.ErrorResponse(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		logging.LogCtx(r.Context(), "WARNING", "Bad Request: "+fmt.Sprintln(err))
+		return
+	}
+	if frontend := middleware.Frontend(r); frontend != "" {
+		for i := range batch {
+			batch[i].FrontendName = frontend
+		}
+	}
+	statusCode := http.StatusOK
+	err = internaldb.InsertCollectedBatch(r.Context(), batch)
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		logging.LogCtx(r.Context(), "ERROR", "error inserting batch into database: "+fmt.Sprintln(err))
+		for i := range results {
+			if results[i].Status == AcceptedStatus {
+				results[i].Status = RejectedStatus
+				results[i].Error = "database error"
+			}
+		}
+	}
+	summary := internaldb.BatchSummary{Results: results}
+	for _, result := range results {
+		if result.Status == AcceptedStatus {
+			summary.Accepted++
+		} else {
+			summary.Rejected++
+		}
+	}
+	jsonSummary, err := json.Marshal(summary)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logging.LogCtx(r.Context(), "ERROR", "error marshalling json data: "+fmt.Sprintln(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(jsonSummary)
+	if err != nil {
+		logging.LogCtx(r.Context(), "ERROR", "error sending/writing data to user: "+fmt.Sprintln(err))
+	}
+}
+
+// decodeJSONBatch decodes a JSON array of records, rejecting individual records rather than the whole batch where possible
+func decodeJSONBatch(body io.Reader) ([]internaldb.CollectionData, []internaldb.BatchRecordResult, error) {
+	var rawRecords []json.RawMessage
+	err := json.NewDecoder(body).Decode(&rawRecords)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rawRecords) > MaxBatchRecords {
+		return nil, nil, fmt.Errorf("batch exceeds %d records", MaxBatchRecords)
+	}
+	batch := make([]internaldb.CollectionData, 0, len(rawRecords))
+	results := make([]internaldb.BatchRecordResult, 0, len(rawRecords))
+	for i, rawRecord := range rawRecords {
+		var collectionData internaldb.CollectionData
+		errRecord := validateBatchRecord(json.Unmarshal(rawRecord, &collectionData), collectionData)
+		results = append(results, batchRecordResult(i, errRecord))
+		if errRecord == nil {
+			batch = append(batch, collectionData)
+		}
+	}
+	return batch, results, nil
+}
+
+// decodeNDJSONBatch decodes a newline delimited stream of records, skipping blank lines
+func decodeNDJSONBatch(body io.Reader) ([]internaldb.CollectionData, []internaldb.BatchRecordResult, error) {
+	var batch []internaldb.CollectionData
+	var results []internaldb.BatchRecordResult
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), MaxBatchBytes)
+	i := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if i >= MaxBatchRecords {
+			return nil, nil, fmt.Errorf("batch exceeds %d records", MaxBatchRecords)
+		}
+		var collectionData internaldb.CollectionData
+		errRecord := validateBatchRecord(json.Unmarshal(line, &collectionData), collectionData)
+		results = append(results, batchRecordResult(i, errRecord))
+		if errRecord == nil {
+			batch = append(batch, collectionData)
+		}
+		i++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return batch, results, nil
+}
+
+// validateBatchRecord checks a decoded record, turning type errors into the same messages CollectHandler returns
+func validateBatchRecord(errDecode error, collectionData internaldb.CollectionData) error {
+	var unmarshalErr *json.UnmarshalTypeError
+	if errors.As(errDecode, &unmarshalErr) {
+		return errors.New("wrong type provided for field: " + unmarshalErr.Field)
+	}
+	if errDecode != nil {
+		return errDecode
+	}
+	if collectionData.IP == "" {
+		return errors.New("ip is required")
+	}
+	return nil
+}
+
+func batchRecordResult(index int, errRecord error) internaldb.BatchRecordResult {
+	if errRecord != nil {
+		return internaldb.BatchRecordResult{Index: index, Status: RejectedStatus, Error: errRecord.Error()}
 	}
+	return internaldb.BatchRecordResult{Index: index, Status: AcceptedStatus}
 }
 
-// BannedHandler Endpoint to check the DB for banned IP's
+// BannedHandler Endpoint to check, list, create and lift bans. GET with a 'banned' query checks a single IP, otherwise it lists bans page by page
 func BannedHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/api/banned" {
 		http.Error(w, "404 not found.", http.StatusNotFound)
 		return
 	}
-	if r.Header.Get("Content-Type") != "application/json" {
-		helper.ErrorResponse(w, "Bad Request: ", http.StatusBadRequest)
-		logging.LogIt("collectHandler", "WARNING", "invalid 'Content-Type' received")
-	}
 	var bannedData internaldb.BannedData
 	switch r.Method {
 	case GET:
+		if !r.URL.Query().Has("banned") {
+			listBans(w, r)
+			return
+		}
 		// get data from banned table
-		errCheck := bannedData.BannedCheck(r.URL.Query().Get("banned"))
+		if _, errAddr := netip.ParseAddr(r.URL.Query().Get("banned")); errAddr != nil {
+			helper.ErrorResponse(w, "Bad Request: banned query parameter must be an ip address", http.StatusBadRequest)
+			return
+		}
+		errCheck := bannedData.BannedCheck(r.Context(), r.URL.Query().Get("banned"))
 		if errCheck != nil {
 			http.Error(w, errCheck.Error(), http.StatusInternalServerError)
-			logging.LogIt("collectHandler", "ERROR", "error querying the database: "+fmt.Sprintln(errCheck))
+			logging.LogCtx(r.Context(), "ERROR", "error querying the database: "+fmt.Sprintln(errCheck))
 			return
 		}
 		jsonFromDB, err := json.Marshal(bannedData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			logging.LogIt("collectHandler", "ERROR", "error marshalling json data: "+fmt.Sprintln(err))
+			logging.LogCtx(r.Context(), "ERROR", "error marshalling json data: "+fmt.Sprintln(err))
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_, err = w.Write(jsonFromDB)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			logging.LogIt("collectHandler", "ERROR", "error sending/writing data to user: "+fmt.Sprintln(err))
+			logging.LogCtx(r.Context(), "ERROR", "error sending/writing data to user: "+fmt.Sprintln(err))
 			return
 		}
 		return
 	case POST:
-		// provide function here, or send error back?
+		if r.Header.Get("Content-Type") != "application/json" {
+			helper.ErrorResponse(w, "Content Type is not application/json", http.StatusUnsupportedMediaType)
+			logging.LogCtx(r.Context(), "WARNING", "invalid 'Content-Type' received")
+			return
+		}
+		err := json.NewDecoder(r.Body).Decode(&bannedData)
+		if err != nil {
+			helper.ErrorResponse(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			logging.LogCtx(r.Context(), "WARNING", "Bad Request: "+fmt.Sprintln(err))
+			return
+		}
+		network, errNetwork := helper.ParseNetwork(bannedData.IP)
+		if errNetwork != nil {
+			helper.ErrorResponse(w, "Bad Request: invalid ip address or network", http.StatusBadRequest)
+			logging.LogCtx(r.Context(), "WARNING", "invalid ip address or network received: "+bannedData.IP)
+			return
+		}
+		bannedData.IP = network.String()
+		if network.IsSingleIP() {
+			bannedData.IP = network.Addr().String()
+		}
+		if bannedData.ExpiresAt < 0 {
+			helper.ErrorResponse(w, "Bad Request: expiresAt must be a unix timestamp", http.StatusBadRequest)
+			return
+		}
+		if bannedData.Duration != "" {
+			duration, errDuration := time.ParseDuration(bannedData.Duration)
+			if errDuration != nil || duration <= 0 {
+				helper.ErrorResponse(w, "Bad Request: duration must be a positive duration, e.g. 24h", http.StatusBadRequest)
+				return
+			}
+			bannedData.ExpiresAt = time.Now().Add(duration).Unix()
+			bannedData.Duration = ""
+		}
+		err = bannedData.InsertBan(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			logging.LogCtx(r.Context(), "ERROR", "error inserting ban into database: "+fmt.Sprintln(err))
+			return
+		}
+		logging.LogCtx(r.Context(), "INFO", bannedData.IP+" has been banned: "+bannedData.Reason)
+		helper.JSONResponse(w, bannedData, http.StatusCreated)
+	case DELETE:
+		ipAddress := r.URL.Query().Get("ip")
+		network, errNetwork := helper.ParseNetwork(ipAddress)
+		if errNetwork != nil {
+			helper.ErrorResponse(w, "Bad Request: ip query parameter must be an ip address or network", http.StatusBadRequest)
+			return
+		}
+		lifted, err := internaldb.DeleteBan(r.Context(), network.String(), r.URL.Query().Get("domainname"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			logging.LogCtx(r.Context(), "ERROR", "error deleting ban from database: "+fmt.Sprintln(err))
+			return
+		}
+		if lifted == 0 {
+			helper.ErrorResponse(w, "no ban found for "+ipAddress, http.StatusNotFound)
+			return
+		}
+		logging.LogCtx(r.Context(), "INFO", "ban lifted for "+ipAddress)
+		helper.ErrorResponse(w, "ban lifted for "+ipAddress, http.StatusOK)
 	default:
 		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
-		logging.LogIt("collectHandler", "WARNING", "received invalid method")
+		logging.LogCtx(r.Context(), "WARNING", "received invalid method")
 	}
 }
 
-// FetchAllCollectedHandler Endpoint to fetch  all collected data
-func FetchAllCollectedHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/api/fetchall" {
+// listBans sends a single page of bans, taking 'page' and 'limit' query parameters
+func listBans(w http.ResponseWriter, r *http.Request) {
+	page, limit := 1, DefaultBanPageSize
+	var err error
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			helper.ErrorResponse(w, "Bad Request: page must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxBanPageSize {
+			helper.ErrorResponse(w, "Bad Request: limit must be between 1 and "+strconv.Itoa(MaxBanPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	bannedList, err := internaldb.ListBans(r.Context(), page, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logging.LogCtx(r.Context(), "ERROR", "error querying the database: "+fmt.Sprintln(err))
+		return
+	}
+	helper.JSONResponse(w, bannedList, http.StatusOK)
+}
+
+// FrontendsHandler Endpoint to list (GET /api/frontends), fetch (GET /api/frontends/<name>) and register (POST /api/frontends) frontends.
+// Frontends not heard from within 'silentAfter' (5m unless given) are marked silent, and 'silent=true' lists only those
+func FrontendsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/frontends" && !strings.HasPrefix(r.URL.Path, "/api/frontends/") {
 		http.Error(w, "404 not found.", http.StatusNotFound)
 		return
 	}
-	if r.Header.Get("Content-Type") != "application/json" {
-		helper.ErrorResponse(w, "Bad Request: ", http.StatusBadRequest)
-		logging.LogIt("collectHandler", "WARNING", "invalid 'Content-Type' received")
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/frontends"), "/")
+	silentAfter := DefaultFrontendSilentAfter
+	if value := r.URL.Query().Get("silentAfter"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			helper.ErrorResponse(w, "Bad Request: silentAfter must be a positive duration, e.g. 10m", http.StatusBadRequest)
+			return
+		}
+		silentAfter = duration
 	}
-	var collectedData internaldb.CollectionData
-	switch r.Method {
-	case GET:
-		// get data from banned table
-		errCheck := collectedData.FetchAll()
-		if errCheck != nil {
-			http.Error(w, errCheck.Error(), http.StatusInternalServerError)
-			logging.LogIt("collectHandler", "ERROR", "error querying the database: "+fmt.Sprintln(errCheck))
+	switch {
+	case r.Method == GET && name != "":
+		frontend, err := internaldb.FetchFrontend(r.Context(), name, silentAfter)
+		if errors.Is(err, ErrFrontendNotFound) {
+			http.Error(w, "404 not found.", http.StatusNotFound)
 			return
 		}
-		jsonFromDB, err := json.Marshal(collectedData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			logging.LogIt("collectHandler", "ERROR", "error marshalling json data: "+fmt.Sprintln(err))
+			logging.LogCtx(r.Context(), "ERROR", "error querying the database: "+fmt.Sprintln(err))
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		_, err = w.Write(jsonFromDB)
+		helper.JSONResponse(w, frontend, http.StatusOK)
+	case r.Method == GET:
+		onlySilent, err := strconv.ParseBool(r.URL.Query().Get("silent"))
+		if r.URL.Query().Has("silent") && err != nil {
+			helper.ErrorResponse(w, "Bad Request: silent must be true or false", http.StatusBadRequest)
+			return
+		}
+		frontends, err := internaldb.ListFrontends(r.Context(), silentAfter)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			logging.LogIt("collectHandler", "ERROR", "error sending/writing data to user: "+fmt.Sprintln(err))
+			logging.LogCtx(r.Context(), "ERROR", "error querying the database: "+fmt.Sprintln(err))
 			return
 		}
-		return
-	case POST:
-		// provide function here, or send error back?
+		if onlySilent {
+			silent := []internaldb.Frontend{}
+			for _, frontend := range frontends {
+				if frontend.Silent {
+					silent = append(silent, frontend)
+				}
+			}
+			frontends = silent
+		}
+		helper.JSONResponse(w, frontends, http.StatusOK)
+	case r.Method == POST && name == "":
+		var frontend internaldb.Frontend
+		err := json.NewDecoder(r.Body).Decode(&frontend)
+		if err != nil {
+			helper.ErrorResponse(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if authenticated := middleware.Frontend(r); authenticated != "" {
+			frontend.Name = authenticated
+		}
+		if frontend.Name == "" {
+			helper.ErrorResponse(w, "Bad Request: name is required", http.StatusBadRequest)
+			return
+		}
+		err = internaldb.RegisterFrontend(r.Context(), frontend.Name)
+		if errors.Is(err, internaldb.ErrFrontendExists) {
+			helper.ErrorResponse(w, "Conflict: frontend already registered", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			logging.LogCtx(r.Context(), "ERROR", "error inserting frontend into database: "+fmt.Sprintln(err))
+			return
+		}
+		helper.JSONResponse(w, internaldb.Frontend{Name: frontend.Name, CreatedAt: time.Now().Unix(), Silent: true}, http.StatusCreated)
 	default:
 		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
-		logging.LogIt("collectHandler", "WARNING", "received invalid method")
+		logging.LogCtx(r.Context(), "WARNING", "received invalid method")
+	}
+}
+
+// CollectedHandler Endpoint to page through collected data, newest first, optionally filtered by frontend, ip, path, method, country and time
+func CollectedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/collected" {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+	if r.Method != GET {
+		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
+		logging.LogCtx(r.Context(), "WARNING", "received invalid method")
+		return
+	}
+	query := r.URL.Query()
+	filter := internaldb.CollectedFilter{
+		Frontend: query.Get("frontend"),
+		IP:       query.Get("ip"),
+		Path:     query.Get("path"),
+		Method:   query.Get("method"),
+		Country:  query.Get("country"),
+		Limit:    DefaultCollectedPageSize,
+	}
+	var errs []string
+	for name, target := range map[string]*int64{"from": &filter.From, "to": &filter.To, "cursor": &filter.Cursor} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || number < 0 {
+			errs = append(errs, name+" must be a non-negative number")
+			continue
+		}
+		*target = number
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxCollectedPageSize {
+			errs = append(errs, "limit must be between 1 and "+strconv.Itoa(MaxCollectedPageSize))
+		}
+		filter.Limit = limit
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		helper.ErrorResponse(w, "Bad Request: "+strings.Join(errs, ", "), http.StatusBadRequest)
+		return
+	}
+	collectedPage, err := internaldb.FetchCollected(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logging.LogCtx(r.Context(), "ERROR", "error querying the database: "+fmt.Sprintln(err))
+		return
+	}
+	helper.JSONResponse(w, collectedPage, http.StatusOK)
+}
+
+// StatsHandler Endpoint for dashboards, aggregating collected data by path, country, frontend, user agent family or time (/api/stats/<group>)
+func StatsHandler(w http.ResponseWriter, r *http.Request) {
+	group := strings.TrimPrefix(r.URL.Path, "/api/stats/")
+	if !internaldb.IsStatsGroup(group) {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+	if r.Method != GET {
+		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
+		logging.LogCtx(r.Context(), "WARNING", "received invalid method")
+		return
+	}
+	statsQuery, errQuery := parseStatsQuery(r.URL.Query(), group, time.Now())
+	if errQuery != nil {
+		helper.ErrorResponse(w, "Bad Request: "+errQuery.Error(), http.StatusBadRequest)
+		return
+	}
+	statsResult, err := internaldb.FetchStats(r.Context(), statsQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logging.LogCtx(r.Context(), "ERROR", "error querying the database: "+fmt.Sprintln(err))
+		return
+	}
+	helper.JSONResponse(w, statsResult, http.StatusOK)
+}
+
+// statsBuckets named time series bucket widths, in seconds
+var statsBuckets = map[string]int64{"minute": 60, "hour": 3600, "day": 86400}
+
+// parseStatsQuery reads the window, either 'window' (a duration back from now) or 'from' and 'to' (unix timestamps), along with 'frontend', 'limit' and 'bucket'
+func parseStatsQuery(query url.Values, group string, now time.Time) (internaldb.StatsQuery, error) {
+	statsQuery := internaldb.StatsQuery{
+		Group:    group,
+		Frontend: query.Get("frontend"),
+		To:       now.Unix(),
+		Limit:    DefaultStatsLimit,
+		Bucket:   statsBuckets["hour"],
+	}
+	window := DefaultStatsWindow
+	if value := query.Get("window"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return statsQuery, errors.New("window must be a positive duration, e.g. 24h")
+		}
+		window = duration
+	}
+	if value := query.Get("to"); value != "" {
+		to, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || to <= 0 {
+			return statsQuery, errors.New("to must be a unix timestamp")
+		}
+		statsQuery.To = to
+	}
+	statsQuery.From = statsQuery.To - int64(window/time.Second)
+	if value := query.Get("from"); value != "" {
+		from, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || from < 0 || from >= statsQuery.To {
+			return statsQuery, errors.New("from must be a unix timestamp before to")
+		}
+		statsQuery.From = from
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxStatsLimit {
+			return statsQuery, errors.New("limit must be between 1 and " + strconv.Itoa(MaxStatsLimit))
+		}
+		statsQuery.Limit = limit
+	}
+	if value := query.Get("bucket"); value != "" {
+		bucket, ok := statsBuckets[value]
+		if !ok {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration < time.Minute {
+				return statsQuery, errors.New("bucket must be minute, hour, day or a duration of at least 1m")
+			}
+			bucket = int64(duration / time.Second)
+		}
+		statsQuery.Bucket = bucket
+	}
+	if group == StatsTimeSeries && (statsQuery.To-statsQuery.From)/statsQuery.Bucket > MaxStatsTimeBuckets {
+		return statsQuery, errors.New("too many buckets, use a larger bucket or a smaller window")
 	}
+	return statsQuery, nil
 }
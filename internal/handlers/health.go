@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+	"zehd-backend/internal/helper"
+	"zehd-backend/internal/internaldb"
+
+	. "zehd-backend/internal"
+)
+
+// Status Struct for the detailed health of the backend, sent by StatusHandler
+type Status struct {
+	Version   string `json:"version"`
+	Backend   string `json:"backend"`
+	StartedAt int64  `json:"startedAt"`
+	// Uptime seconds since the backend started
+	Uptime float64 `json:"uptime"`
+	// LastIngest unix time this backend last wrote collected data, 0 if it did not since it started
+	LastIngest int64                     `json:"lastIngest"`
+	Database   internaldb.DatabaseHealth `json:"database"`
+}
+
+// HealthzHandler Liveness endpoint, answering 200 as long as the process serves requests
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != GET && r.Method != http.MethodHead {
+		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// ReadyzHandler Readiness endpoint, answering 200 once the database answers pings and its schema is up to date, 503 otherwise
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != GET && r.Method != http.MethodHead {
+		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), HealthCheckTimeout)
+	defer cancel()
+	health := internaldb.CheckHealth(ctx)
+	switch {
+	case health.Error != "":
+		http.Error(w, "database unavailable: "+health.Error, http.StatusServiceUnavailable)
+	case health.SchemaVersion != health.LatestSchemaVersion:
+		http.Error(w, "schema version "+strconv.Itoa(health.SchemaVersion)+", expected "+strconv.Itoa(health.LatestSchemaVersion), http.StatusServiceUnavailable)
+	default:
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ready"))
+	}
+}
+
+// StatusHandler Endpoint sending the detailed health of the backend: database latency and pool statistics, uptime, build version and
+// last ingest time. It always answers 200, reporting database problems in database.error
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != GET {
+		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), HealthCheckTimeout)
+	defer cancel()
+	helper.JSONResponse(w, Status{
+		Version:    Version,
+		Backend:    Backend,
+		StartedAt:  Started.Unix(),
+		Uptime:     time.Since(Started).Seconds(),
+		LastIngest: internaldb.LastIngest(),
+		Database:   internaldb.CheckHealth(ctx),
+	}, http.StatusOK)
+}
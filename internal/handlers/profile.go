@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"zehd-backend/internal/helper"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+// TrackTimeHandler Endpoint sending the latest TrackTime measurements of every task, with their p50, p95 and p99, served on the
+// profiler listener. ?task= limits it to the given tasks, e.g. ?task=InsertCollectedData, and ?recent= to as many measurements per task
+func TrackTimeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != GET {
+		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	recent := 0
+	if value := r.URL.Query().Get("recent"); value != "" {
+		var err error
+		recent, err = strconv.Atoi(value)
+		if err != nil || recent < 0 {
+			helper.ErrorResponse(w, "Bad Request: recent must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	helper.JSONResponse(w, logging.Profile(recent, r.URL.Query()["task"]...), http.StatusOK)
+}
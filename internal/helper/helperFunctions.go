@@ -3,6 +3,7 @@ package helper
 import (
 	"encoding/json"
 	"net/http"
+	"net/netip"
 )
 
 // ErrorResponse Boilerplate error response
@@ -18,3 +19,37 @@ func ErrorResponse(w http.ResponseWriter, message string, httpStatusCode int) {
 		return
 	}
 }
+
+// JSONResponse Boilerplate JSON response, for sending data back with the given status code
+func JSONResponse(w http.ResponseWriter, data interface{}, httpStatusCode int) {
+	jsonResp, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusCode)
+	_, err = w.Write(jsonResp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ParseNetwork Parse a single IP address or a CIDR network (e.g. "192.0.2.0/24" or "2001:db8::/64") into a network prefix.
+// Single addresses become a /32 or /128 and host bits of networks are masked off, so the result is always canonical
+func ParseNetwork(value string) (netip.Prefix, error) {
+	prefix, errPrefix := netip.ParsePrefix(value)
+	if errPrefix == nil {
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96).Masked(), nil
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
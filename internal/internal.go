@@ -1,12 +1,16 @@
 package internal
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
 
 const (
-	GET  = "GET"
-	POST = "POST"
+	GET    = "GET"
+	POST   = "POST"
+	DELETE = "DELETE"
 	// PUT    = "PUT"
-	// DELETE = "DELETE"
 	// PATCH = "PATCH"
 )
 
@@ -14,6 +18,7 @@ type DatabaseExists struct {
 	Frontend   string `json:"frontend"`
 	Connection string `json:"connection"`
 	Tables     string `json:"tables"`
+	Version    string `json:"version,omitempty"`
 }
 
 const (
@@ -27,40 +32,156 @@ const (
 	DbName       = "DBNAME"
 )
 
-// table columns constants
+// database pool and reconnection defaults
+const (
+	DefaultMaxOpenConns    = 25
+	DefaultMaxIdleConns    = 5
+	DefaultConnMaxLifetime = 30 * time.Minute
+	DefaultConnMaxIdleTime = 5 * time.Minute
+	DefaultDBRetryMin      = time.Second
+	DefaultDBRetryMax      = time.Minute
+	DefaultDBCheckInterval = 10 * time.Second
+)
+
+// DefaultShutdownTimeout how long in-flight requests and background jobs are given to finish on shutdown
+const DefaultShutdownTimeout = 30 * time.Second
+
+// DefaultCertReloadInterval how often TLS certificate files are checked for changes
+const DefaultCertReloadInterval = 10 * time.Second
+
+// log rotation defaults
+const (
+	DefaultLogMaxSize    = 100
+	DefaultLogMaxBackups = 10
+)
+
+// log sink defaults
+const (
+	// JournalSocket the socket journald receives native protocol messages on
+	JournalSocket        = "/run/systemd/journal/socket"
+	DefaultSyslogAddress = "unix:///dev/log"
+	DefaultSyslogTag     = "zehd-backend"
+)
+
+// profiler defaults
+const (
+	DefaultProfilerListen  = "127.0.0.1:6060"
+	DefaultProfilerSamples = 1000
+)
+
+// DefaultEnvFile the .env file loaded on startup, unless --env-file is given
+const DefaultEnvFile = "/usr/local/env/.env"
+
+// BannedArchiveTable holds bans the sweeper has removed from BannedTable after they expired
+const BannedArchiveTable = "banned_archive_table"
+
+// rollup tables, aggregating CollectTable per hour and per day
+const (
+	HourlyRollupTable = "collect_rollup_hourly"
+	DailyRollupTable  = "collect_rollup_daily"
+	// RollupStateTable remembers the last CollectTable row rolled up
+	RollupStateTable = "rollup_state"
+)
+
+// FrontendTable holds the frontends allowed to send data, and the API keys they sign requests with
+const FrontendTable = "frontend_table"
+
+// ErrFrontendNotFound returned when a frontend is not registered
+var ErrFrontendNotFound = errors.New("frontend not found")
+
+// DefaultFrontendSilentAfter how long a frontend may go without a heartbeat before it is reported silent
+const DefaultFrontendSilentAfter = 5 * time.Minute
+
+// request signing, see middleware.RequireSignature
 const (
-	CollectedTableColumns = `unique_id SERIAL NOT NULL,
-frontend TEXT,
-backend TEXT,
-ip TEXT,
-port INT,
-path TEXT,
-method TEXT,
-xforwardfor TEXT,
-xrealip TEXT,
-useragent TEXT,
-via TEXT,
-age TEXT,
-timedate BIGINT,
-checked BOOL,
-banned BOOL,
-cfipcountry TEXT,
-PRIMARY KEY (unique_id)`
-	CheckedTableColumns = `unique_id SERIAL NOT NULL,
-ip TEXT,
-domainname TEXT,
-timechecked BIGINT,
-PRIMARY KEY (unique_id)`
-	BannedTableColumns = `unique_id SERIAL NOT NULL,
-ip TEXT,
-domainname TEXT,
-timechecked BIGINT,
-timebanned BIGINT,
-PRIMARY KEY (unique_id)`
-	FailedStatus = "failed"
+	FrontendHeader  = "X-Zehd-Frontend"
+	TimestampHeader = "X-Zehd-Timestamp"
+	SignatureHeader = "X-Zehd-Signature"
+	// DefaultSignatureMaxAge how far a signed request's timestamp may be from the backend's clock
+	DefaultSignatureMaxAge = 5 * time.Minute
 )
 
+// RequestIDHeader identifies a request in the logs of the frontend and the backend. Frontends may send one, otherwise one is assigned
+const RequestIDHeader = "X-Request-ID"
+
+// BannedChannel the channel BannedTable notifies, through a trigger, whenever bans are added, changed or removed
+const BannedChannel = "banned_table_changed"
+
+// schema migration constants
+const (
+	SchemaMigrationsTable        = "schema_migrations"
+	SchemaMigrationsTableColumns = `version INT NOT NULL,
+name TEXT NOT NULL,
+applied_at BIGINT NOT NULL,
+PRIMARY KEY (version)`
+	// MigrationLockID the advisory lock held while migrating, so only one backend migrates at a time
+	MigrationLockID = 7265686400
+	// CollectLockID the advisory lock inserts into CollectTable share until they commit, and rollups briefly take exclusively to read
+	// a watermark no uncommitted row falls below
+	CollectLockID = 7265686401
+)
+
+const FailedStatus = "failed"
+
+// background job defaults
+const (
+	// DefaultBanSweepInterval how often expired bans are archived, unless BANSWEEPINTERVAL is set
+	DefaultBanSweepInterval = time.Minute
+	// DefaultBanCachePoll how often the ban cache is reloaded without any notification, unless BANCACHEPOLL is set
+	DefaultBanCachePoll = 30 * time.Second
+	// DefaultRollupInterval how often new collected rows are added to the rollup tables, unless ROLLUPINTERVAL is set
+	DefaultRollupInterval = 5 * time.Minute
+	// DefaultRetentionInterval how often the retention policy is applied, unless RETENTIONINTERVAL is set
+	DefaultRetentionInterval = time.Hour
+	// PruneChunkSize how many rows retention archives and deletes per transaction
+	PruneChunkSize = 10000
+)
+
+// banned listing constants
+const (
+	DefaultBanPageSize = 50
+	MaxBanPageSize     = 500
+)
+
+// collected listing constants
+const (
+	DefaultCollectedPageSize = 100
+	MaxCollectedPageSize     = 1000
+)
+
+// stats constants, the groups are the last element of /api/stats/<group>
+const (
+	StatsByPath         = "paths"
+	StatsByCountry      = "countries"
+	StatsByFrontend     = "frontends"
+	StatsByUserAgent    = "useragents"
+	StatsTimeSeries     = "timeseries"
+	DefaultStatsWindow  = 24 * time.Hour
+	DefaultStatsLimit   = 10
+	MaxStatsLimit       = 100
+	MaxStatsTimeBuckets = 10000
+)
+
+// batch ingestion constants
+const (
+	AcceptedStatus  = "accepted"
+	RejectedStatus  = "rejected"
+	MaxBatchRecords = 5000
+	MaxBatchBytes   = 16 << 20
+	// InsertChunkSize keeps multi-row inserts below postgres' 65535 bind parameter limit
+	InsertChunkSize = 1000
+)
+
+// HealthCheckTimeout how long health checks wait for the database
+const HealthCheckTimeout = 2 * time.Second
+
 var (
 	Db      *sql.DB
 	Backend string
+	// Conf the configuration main loaded on startup
+	Conf *Config
+	// Version the build version, set with -ldflags "-X zehd-backend/internal.Version=<version>"
+	Version = "dev"
+	// Started when the backend started
+	Started = time.Now()
 )
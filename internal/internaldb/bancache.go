@@ -0,0 +1,140 @@
+package internaldb
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+	"time"
+	"zehd-backend/internal/bancache"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	// banCache answers BannedCheck from memory once StartBanCache has loaded it
+	banCache bancache.Cache[BannedData]
+	// banCacheStarted guards reloads after writes, which are pointless while the cache is unused
+	banCacheStarted atomic.Bool
+)
+
+// LoadBanCache Load every ban that has not expired yet into the in-memory ban cache
+func LoadBanCache() error {
+	defer logging.TrackTime("LoadBanCache", time.Now())
+	query := "SELECT " + bannedColumns + " FROM " + BannedTable + `
+WHERE network IS NOT NULL AND (expires_at IS NULL OR expires_at > $1)
+ORDER BY unique_id;`
+	rows, dbCheck := Db.Query(query, time.Now().Unix())
+	if dbCheck != nil {
+		logging.LogIt("LoadBanCache", "ERROR", "unable to query db")
+		return dbCheck
+	}
+	defer func() {
+		errClose := rows.Close()
+		if errClose != nil {
+			logging.LogIt("LoadBanCache", "ERROR", "error closing query")
+		}
+	}()
+	tree := bancache.NewTree[BannedData]()
+	for rows.Next() {
+		bannedData := BannedData{Banned: true}
+		errRows := scanBan(rows, &bannedData)
+		if errRows != nil {
+			logging.LogIt("LoadBanCache", "ERROR", "unable to scan rows")
+			return errRows
+		}
+		prefix, err := netip.ParsePrefix(bannedData.Prefix)
+		if err != nil {
+			logging.LogIt("LoadBanCache", "WARNING", "skipping ban with invalid network: "+bannedData.Prefix)
+			continue
+		}
+		tree.Insert(prefix, bannedData)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	banCache.Replace(tree)
+	return nil
+}
+
+// StartBanCache Load the ban cache and keep it fresh, in the background, until ctx is cancelled. Changes are picked up as soon as
+// banned_table notifies BannedChannel, and every pollInterval regardless, in case the listening connection is lost
+func StartBanCache(ctx context.Context, pollInterval time.Duration) error {
+	err := LoadBanCache()
+	if err != nil {
+		return err
+	}
+	banCacheStarted.Store(true)
+	Go(func() {
+		for ctx.Err() == nil {
+			errListen := listenForBanChanges(ctx, pollInterval)
+			if errListen != nil && ctx.Err() == nil {
+				logging.LogIt("StartBanCache", "WARNING", "not listening for ban changes, polling instead: "+fmt.Sprintln(errListen))
+				select {
+				case <-ctx.Done():
+				case <-time.After(pollInterval):
+					reloadBanCache()
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// listenForBanChanges reloads the ban cache on every notification, or when none arrived for pollInterval, until the connection fails
+func listenForBanChanges(ctx context.Context, pollInterval time.Duration) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		errClose := conn.Close(context.Background())
+		if errClose != nil {
+			logging.LogIt("listenForBanChanges", "ERROR", "unable to close listening connection")
+		}
+	}()
+	_, err = conn.Exec(ctx, "LISTEN "+BannedChannel+";")
+	if err != nil {
+		return err
+	}
+	// bans may have changed while nobody was listening
+	reloadBanCache()
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, pollInterval)
+		_, err = conn.WaitForNotification(waitCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && waitCtx.Err() == nil {
+			return err
+		}
+		reloadBanCache()
+	}
+}
+
+// reloadBanCache reloads the ban cache, if it is in use, logging rather than returning failures
+func reloadBanCache() {
+	if !banCacheStarted.Load() {
+		return
+	}
+	err := LoadBanCache()
+	if err != nil {
+		logging.LogIt("reloadBanCache", "ERROR", "unable to reload ban cache: "+fmt.Sprintln(err))
+	}
+}
+
+// cachedBannedCheck answers BannedCheck from the ban cache. ok is false when the cache is not loaded
+func cachedBannedCheck(addr netip.Addr) (bannedData BannedData, banned bool, ok bool) {
+	if !banCache.Ready() {
+		return bannedData, false, false
+	}
+	now := time.Now().Unix()
+	bannedData, banned = banCache.Lookup(addr, func(ban BannedData) bool {
+		return ban.ExpiresAt == 0 || ban.ExpiresAt > now
+	})
+	return bannedData, banned, true
+}
@@ -0,0 +1,199 @@
+package internaldb
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+// ErrFrontendExists returned when adding a frontend under a name already taken
+var ErrFrontendExists = errors.New("frontend already exists")
+
+// newAPIKey generates a random API key, hex encoded
+func newAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// AddFrontend Register a frontend, returning the API key it signs requests with. Frontends only known from their heartbeats are given a key
+func AddFrontend(name string) (string, error) {
+	defer logging.TrackTime("AddFrontend", time.Now())
+	key, err := newAPIKey()
+	if err != nil {
+		return "", err
+	}
+	result, err := Db.Exec(`
+INSERT INTO `+FrontendTable+` (name, api_key, created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (name) DO UPDATE SET api_key=excluded.api_key
+WHERE `+FrontendTable+`.api_key IS NULL;`, name, key, time.Now().Unix())
+	if err != nil {
+		logging.LogIt("AddFrontend", "ERROR", "unable to insert frontend")
+		return "", err
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if added == 0 {
+		return "", ErrFrontendExists
+	}
+	return key, nil
+}
+
+// RotateFrontendKey Replace the API key of a frontend, returning the new key. Requests signed with the old key are refused from then on
+func RotateFrontendKey(name string) (string, error) {
+	defer logging.TrackTime("RotateFrontendKey", time.Now())
+	key, err := newAPIKey()
+	if err != nil {
+		return "", err
+	}
+	result, err := Db.Exec("UPDATE "+FrontendTable+" SET api_key=$2 WHERE name=$1;", name, key)
+	if err != nil {
+		logging.LogIt("RotateFrontendKey", "ERROR", "unable to update frontend")
+		return "", err
+	}
+	rotated, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rotated == 0 {
+		return "", ErrFrontendNotFound
+	}
+	return key, nil
+}
+
+// RemoveFrontend Remove a frontend, so its requests are refused
+func RemoveFrontend(name string) error {
+	defer logging.TrackTime("RemoveFrontend", time.Now())
+	result, err := Db.Exec("DELETE FROM "+FrontendTable+" WHERE name=$1;", name)
+	if err != nil {
+		logging.LogIt("RemoveFrontend", "ERROR", "unable to delete frontend")
+		return err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrFrontendNotFound
+	}
+	return nil
+}
+
+// FrontendKey Fetch the API key of a frontend
+func FrontendKey(ctx context.Context, name string) (string, error) {
+	defer logging.TrackTime("FrontendKey", time.Now())
+	var key sql.NullString
+	err := Db.QueryRowContext(ctx, "SELECT api_key FROM "+FrontendTable+" WHERE name=$1;", name).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !key.Valid) {
+		return "", ErrFrontendNotFound
+	}
+	if err != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to query frontend")
+		return "", err
+	}
+	return key.String, nil
+}
+
+// frontendColumns the columns scanFrontend expects, in order
+const frontendColumns = `name, created_at, COALESCE(last_seen, 0), COALESCE(version, ''), COALESCE(connection, ''), api_key IS NOT NULL`
+
+// scanFrontend scans a row of frontendColumns, marking the frontend silent when not heard from within silentAfter of now
+func scanFrontend(row interface{ Scan(...interface{}) error }, frontend *Frontend, now int64, silentAfter time.Duration) error {
+	err := row.Scan(&frontend.Name, &frontend.CreatedAt, &frontend.LastSeen, &frontend.Version, &frontend.Connection, &frontend.HasKey)
+	frontend.Silent = now-frontend.LastSeen > int64(silentAfter.Seconds())
+	return err
+}
+
+// RegisterFrontend Register a frontend without an API key, to be given one with AddFrontend
+func RegisterFrontend(ctx context.Context, name string) error {
+	defer logging.TrackTime("RegisterFrontend", time.Now())
+	result, err := Db.ExecContext(ctx, `
+INSERT INTO `+FrontendTable+` (name, created_at)
+VALUES ($1, $2)
+ON CONFLICT (name) DO NOTHING;`, name, time.Now().Unix())
+	if err != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to insert frontend")
+		return err
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return ErrFrontendExists
+	}
+	return nil
+}
+
+// RecordHeartbeat Record that the frontend was just heard from, along with its version and database connection status, registering it
+// if it is not yet
+func RecordHeartbeat(ctx context.Context, name, version, connection string) error {
+	defer logging.TrackTime("RecordHeartbeat", time.Now())
+	now := time.Now().Unix()
+	_, err := Db.ExecContext(ctx, `
+INSERT INTO `+FrontendTable+` (name, created_at, last_seen, version, connection)
+VALUES ($1, $2, $2, NULLIF($3, ''), NULLIF($4, ''))
+ON CONFLICT (name) DO UPDATE SET
+	last_seen=excluded.last_seen,
+	version=COALESCE(excluded.version, `+FrontendTable+`.version),
+	connection=COALESCE(excluded.connection, `+FrontendTable+`.connection);`, name, now, version, connection)
+	if err != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to record heartbeat")
+	}
+	return err
+}
+
+// FetchFrontend Fetch a single frontend, marked silent when not heard from within silentAfter
+func FetchFrontend(ctx context.Context, name string, silentAfter time.Duration) (Frontend, error) {
+	defer logging.TrackTime("FetchFrontend", time.Now())
+	var frontend Frontend
+	row := Db.QueryRowContext(ctx, "SELECT "+frontendColumns+" FROM "+FrontendTable+" WHERE name=$1;", name)
+	err := scanFrontend(row, &frontend, time.Now().Unix(), silentAfter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return frontend, ErrFrontendNotFound
+	}
+	if err != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to query frontend")
+	}
+	return frontend, err
+}
+
+// ListFrontends List every registered frontend by name, marked silent when not heard from within silentAfter. API keys are left out
+func ListFrontends(ctx context.Context, silentAfter time.Duration) ([]Frontend, error) {
+	defer logging.TrackTime("ListFrontends", time.Now())
+	rows, err := Db.QueryContext(ctx, "SELECT "+frontendColumns+" FROM "+FrontendTable+" ORDER BY name;")
+	if err != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to query frontends")
+		return nil, err
+	}
+	defer func() {
+		errClose := rows.Close()
+		if errClose != nil {
+			logging.LogCtx(ctx, "ERROR", "error closing query")
+		}
+	}()
+	now := time.Now().Unix()
+	frontends := []Frontend{}
+	for rows.Next() {
+		var frontend Frontend
+		err = scanFrontend(rows, &frontend, now, silentAfter)
+		if err != nil {
+			logging.LogCtx(ctx, "ERROR", "unable to scan rows")
+			return nil, err
+		}
+		frontends = append(frontends, frontend)
+	}
+	return frontends, rows.Err()
+}
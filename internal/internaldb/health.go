@@ -0,0 +1,52 @@
+package internaldb
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+// lastIngest unix time collected data was last written by this backend
+var lastIngest atomic.Int64
+
+// LastIngest Unix time collected data was last written by this backend, 0 if none was since it started
+func LastIngest() int64 {
+	return lastIngest.Load()
+}
+
+// CheckHealth Ping the database and read its schema version, without changing anything. Error is set when either failed
+func CheckHealth(ctx context.Context) DatabaseHealth {
+	defer logging.TrackTime("CheckHealth", time.Now())
+	health := DatabaseHealth{Available: Available()}
+	if Db == nil {
+		health.Error = "not connected"
+		return health
+	}
+	stats := Db.Stats()
+	health.Pool = PoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDuration:       stats.WaitDuration.Seconds(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+	start := time.Now()
+	err := Db.PingContext(ctx)
+	health.Latency = time.Since(start).Seconds()
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.SchemaVersion, health.LatestSchemaVersion, err = SchemaVersion()
+	if err != nil {
+		health.Error = err.Error()
+	}
+	return health
+}
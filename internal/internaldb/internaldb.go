@@ -1,193 +1,280 @@
 package internaldb
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"zehd-backend/internal/logging"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "zehd-backend/internal"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/mitchellh/go-ps"
-
-	"github.com/joho/godotenv"
 )
 
-// dbConfig this function is run within the initDB function, in order to connect, check, or create DB and table
-func dbConfig() (map[string]string, error) {
-	defer logging.TrackTime("dbConfig", time.Now())
-	errEnv := godotenv.Load("/usr/local/env/.env")
-	if errEnv != nil {
-		logging.LogIt("dbConfig", "ERROR", "error loading .env variables")
-	}
-	conf := make(map[string]string)
-	var emptyVar error
-	partialErrMessage := "environment variable required but not set, check logs for more details"
-	host, ok := os.LookupEnv(DbHost)
-	if !ok {
-		conf[DbHost] = "localhost"
-		emptyVar = fmt.Errorf("dbhost " + partialErrMessage)
-		logging.LogIt("dbConfig", "ERROR", "DBHOST environment variable empty or non-existent. Defaulting to 'localhost'")
-	}
-	port, ok := os.LookupEnv(DbPort)
-	if !ok {
-		conf[DbPort] = ""
-		emptyVar = fmt.Errorf("dbport " + partialErrMessage)
-		logging.LogIt("dbConfig", "ERROR", "Unable to configure DB, DBPORT empty.")
-	}
-	user, ok := os.LookupEnv(DbUser)
-	if !ok {
-		conf[DbUser] = ""
-		emptyVar = fmt.Errorf("dbuser " + partialErrMessage)
-		logging.LogIt("dbConfig", "ERROR", "Unable to configure DB, DBUSER empty.")
-	}
-	password, ok := os.LookupEnv(DbPass)
-	if !ok {
-		conf[DbPass] = ""
-		emptyVar = fmt.Errorf("dbpass " + partialErrMessage)
-		logging.LogIt("dbConfig", "ERROR", "Unable to configure DB, DBPASS empty.")
-	}
-	name, ok := os.LookupEnv(DbName)
-	if !ok {
-		conf[DbName] = ""
-		emptyVar = fmt.Errorf("dbname " + partialErrMessage)
-		logging.LogIt("dbConfig", "ERROR", "Unable to configure DB, DBNAME empty.")
-	}
-	if emptyVar != nil {
-		return conf, emptyVar
-	}
-	conf[DbHost] = host
-	conf[DbPort] = port
-	conf[DbUser] = user
-	conf[DbPass] = password
-	conf[DbName] = name
-	return conf, nil
+// dsn the connection string InitDB connected with, including its SSL settings, kept for connections opened outside of Db, such as LISTEN
+var dsn string
+
+// jobs the background jobs started through Go, waited for by Close
+var jobs sync.WaitGroup
+
+// Go Run job in the background, so Close waits for it to return before closing the pool. Jobs must return once their context is cancelled
+func Go(job func()) {
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		job()
+	}()
+}
+
+// Close Wait for background jobs to return, or for ctx to be done, then close the pool, waiting for queries already running to finish
+func Close(ctx context.Context) error {
+	defer logging.TrackTime("Close", time.Now())
+	stopped := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logging.LogIt("Close", "WARNING", "background jobs still running, closing the database anyway")
+	}
+	if Db == nil {
+		return nil
+	}
+	return Db.Close()
+}
+
+// dbConfig this function is run within the initDB function, returning the database settings of the loaded configuration
+func dbConfig() (DBConfig, error) {
+	if Conf == nil {
+		return DBConfig{}, errors.New("configuration not loaded")
+	}
+	return Conf.DB, nil
 }
 
-// InitDB Initialize the DB
-func InitDB() (string, error) {
+// InitDB Initialize the DB, connecting to it and applying any pending migrations until ctx is cancelled
+func InitDB(ctx context.Context) (string, error) {
 	defer logging.TrackTime("InitDB", time.Now())
+	status, err := ConnectDB()
+	if err != nil {
+		return status, err
+	}
+	fmt.Printf("Migrating database schema: ")
+	applied, err := MigrateUp(ctx)
+	if err != nil {
+		logging.LogIt("InitDB", "ERROR", "unable to migrate database schema.")
+		fmt.Println("Failed!")
+		return FailedStatus, err
+	}
+	fmt.Printf("%d migrations applied\n", applied)
+	return "exists", nil
+}
+
+// ConnectDB Connect to the DB, without touching its schema
+func ConnectDB() (string, error) {
+	defer logging.TrackTime("ConnectDB", time.Now())
 	var hostnameErr, err error
 	Backend, hostnameErr = os.Hostname()
 	if hostnameErr != nil {
-		logging.LogIt("InitDb", "ERROR", "unable to configure database, unable to get hostname")
+		logging.LogIt("ConnectDB", "ERROR", "unable to configure database, unable to get hostname")
 		return FailedStatus, hostnameErr
 	}
 	config, errConfig := dbConfig()
 	if errConfig != nil {
-		logging.LogIt("InitDb", "ERROR", "unable to configure database, 1 or more empty environment variables exists.")
-		return "no env var", errConfig
+		logging.LogIt("ConnectDB", "ERROR", "unable to configure database, configuration not loaded.")
+		return "no config", errConfig
+	}
+	psqlInfo, errConfig := config.ConnString()
+	if errConfig != nil {
+		logging.LogIt("ConnectDB", "ERROR", "unable to configure database, invalid database URL.")
+		return "no config", errConfig
 	}
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s "+
-		"password=%s database=%s sslmode=disable",
-		config[DbHost], config[DbPort],
-		config[DbUser], config[DbPass], config[DbName])
 	fmt.Printf("\nConnecting to DB server: ")
-	Db, err = sql.Open("pgx", psqlInfo)
-	if err != nil {
-		logging.LogIt("InitDb", "ERROR", "unable to open a connection to database server.")
-		fmt.Println("Connection failed!")
-		fmt.Println(err)
-		return "", err
+	// the pool is kept across calls, so retrying does not leave pools behind and callers of Db are not cut off
+	if Db == nil || dsn != psqlInfo {
+		pool, errOpen := sql.Open("pgx", psqlInfo)
+		if errOpen != nil {
+			logging.LogIt("ConnectDB", "ERROR", "unable to open a connection to database server.")
+			fmt.Println("Connection failed!")
+			fmt.Println(errOpen)
+			return "", errOpen
+		}
+		pool.SetMaxOpenConns(config.MaxOpenConns)
+		pool.SetMaxIdleConns(config.MaxIdleConns)
+		pool.SetConnMaxLifetime(config.ConnMaxLifetime)
+		pool.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+		if Db != nil {
+			errClose := Db.Close()
+			if errClose != nil {
+				logging.LogIt("ConnectDB", "ERROR", "unable to close previous database pool")
+			}
+		}
+		Db, dsn = pool, psqlInfo
 	}
 	fmt.Println("Connected successfully!")
 	fmt.Printf("Pinging DB server: ")
 	err = Db.Ping()
+	available.Store(err == nil)
 	if err != nil {
-		logging.LogIt("InitDB", "ERROR", "unable to ping database.")
+		logging.LogIt("ConnectDB", "ERROR", "unable to ping database.")
 		fmt.Println("Ping failed!")
 		return FailedStatus, err
 	}
 	fmt.Println("Pinged successfully!")
-	fmt.Printf("Checking if database and table exists: ")
-	query := "SELECT * FROM " + CollectTable + ";"
-	_, tableCheck := Db.Query(query)
-	if tableCheck != nil {
-		fmt.Println("Not found")
-		fmt.Printf("Creating new table: ")
-		_, err = Db.Exec("CREATE TABLE " + CollectTable + "(" + CollectedTableColumns + ");")
-		if err != nil {
-			logging.LogIt("InitDb", "ERROR", "unable to create table ("+CollectTable+").")
-			return FailedStatus, err
-		}
-		_, err = Db.Exec("CREATE TABLE " + CheckedTable + "(" + CheckedTableColumns + ");")
-		if err != nil {
-			logging.LogIt("InitDb", "ERROR", "unable to create table ("+CheckedTable+").")
-			return FailedStatus, err
+	return "connected", nil
+}
+
+// available whether the database answered the last ping
+var available atomic.Bool
+
+// Available Whether the database answered the last connection attempt or health check
+func Available() bool {
+	return available.Load()
+}
+
+// nextBackoff doubles the delay between attempts, up to maxDelay
+func nextBackoff(delay, maxDelay time.Duration) time.Duration {
+	if delay*2 > maxDelay {
+		return maxDelay
+	}
+	return delay * 2
+}
+
+// Connect Initialize the DB like InitDB, retrying the connection with exponential backoff between config.RetryMin and config.RetryMax until
+// it succeeds or ctx is cancelled. Migrations are not retried, their error is returned
+func Connect(ctx context.Context) error {
+	config, err := dbConfig()
+	if err != nil {
+		return err
+	}
+	delay := config.RetryMin
+	for {
+		_, err = ConnectDB()
+		if err == nil {
+			break
 		}
-		_, err = Db.Exec("CREATE TABLE " + BannedTable + "(" + BannedTableColumns + ");")
-		if err != nil {
-			logging.LogIt("InitDb", "ERROR", "unable to create table ("+BannedTable+").")
-			return FailedStatus, err
+		logging.LogIt("Connect", "WARNING", "database unavailable, retrying in "+delay.String()+": "+fmt.Sprintln(err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
 		}
-		fmt.Println("Created")
-	} else {
-		fmt.Println("Found")
+		delay = nextBackoff(delay, config.RetryMax)
 	}
-	return "exists", nil
+	fmt.Printf("Migrating database schema: ")
+	applied, err := MigrateUp(ctx)
+	if err != nil {
+		logging.LogIt("Connect", "ERROR", "unable to migrate database schema.")
+		fmt.Println("Failed!")
+		return err
+	}
+	fmt.Printf("%d migrations applied\n", applied)
+	return nil
+}
+
+// StartMonitor Ping the database every config.CheckInterval, or with exponential backoff while it is unavailable, so Available reflects
+// lost and regained connections. The pool reconnects by itself once the database is back
+func StartMonitor(ctx context.Context) {
+	config, err := dbConfig()
+	if err != nil {
+		return
+	}
+	Go(func() {
+		delay := config.CheckInterval
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			pingCtx, cancel := context.WithTimeout(ctx, config.CheckInterval)
+			errPing := Db.PingContext(pingCtx)
+			cancel()
+			switch {
+			case errPing == nil && !available.Load():
+				logging.LogIt("StartMonitor", "INFO", "database available again")
+				delay = config.CheckInterval
+			case errPing != nil && available.Load():
+				logging.LogIt("StartMonitor", "ERROR", "database connection lost: "+fmt.Sprintln(errPing))
+				delay = config.RetryMin
+			case errPing != nil:
+				delay = nextBackoff(delay, config.RetryMax)
+			}
+			if ctx.Err() == nil {
+				available.Store(errPing == nil)
+			}
+		}
+	})
 }
 
 // CheckDB Check if the DB exists
-func CheckDB() (processNotFound string) {
+func CheckDB(ctx context.Context) (processNotFound string) {
 	defer logging.TrackTime("CheckDB", time.Now())
 	if DbHost == "localhost" {
 		processList, err := ps.Processes()
 		if err != nil {
-			logging.LogIt("existHandler", "ERROR", "unable to list processes, in order to find postgres.")
+			logging.LogCtx(ctx, "ERROR", "unable to list processes, in order to find postgres.")
 		}
 		for i := range processList {
 			process := processList[i]
 			if process.Executable() == "postgres" {
-				logging.LogIt("existHandler", "INFO", "postgres was found. (pid: "+strconv.Itoa(process.Pid())+")")
+				logging.LogCtx(ctx, "INFO", "postgres was found. (pid: "+strconv.Itoa(process.Pid())+")")
 			} else {
 				processNotFound = FailedStatus
-				logging.LogIt("existHandler", "INFO", "postgres not found. please make sure it is installed")
+				logging.LogCtx(ctx, "INFO", "postgres not found. please make sure it is installed")
 			}
 		}
 	} else {
 		config, errConfig := dbConfig()
 		if errConfig != nil {
-			logging.LogIt("existHandler", "ERROR", "unable to configure database, 1 or more empty environment variables exists.")
+			logging.LogCtx(ctx, "ERROR", "unable to configure database, configuration not loaded.")
+		}
+		psqlInfo, errConfig := config.ConnString()
+		if errConfig != nil {
+			logging.LogCtx(ctx, "ERROR", "unable to configure database, invalid database URL.")
 		}
-		psqlInfo := fmt.Sprintf("host=%s port=%s user=%s "+
-			"password=%s database=%s sslmode=disable",
-			config[DbHost], config[DbPort],
-			config[DbUser], config[DbPass], config[DbName])
 		db, err := sql.Open("pgx", psqlInfo)
 		if err != nil {
 			processNotFound = FailedStatus
-			logging.LogIt("existHandler", "ERROR", "unable to open a connection to database server.")
+			logging.LogCtx(ctx, "ERROR", "unable to open a connection to database server.")
 		}
 		defer func() {
 			errClose := db.Close()
 			if errClose != nil {
-				logging.LogIt("main", "ERROR", "unable to close database")
+				logging.LogCtx(ctx, "ERROR", "unable to close database")
 			}
 		}()
 		_, dbCheck := db.Query("SELECT * FROM " + CollectTable + ";")
 		if dbCheck != nil {
 			processNotFound = "failed to query " + CollectTable
-			logging.LogIt("existHandler", "ERROR", "database not found. please check your database server")
+			logging.LogCtx(ctx, "ERROR", "database not found. please check your database server")
 			pidQuery, pidCheck := db.Query("SELECT pg_backend_pid();")
 			if pidCheck != nil {
 				processNotFound = "failed to query pid"
-				logging.LogIt("existHandler", "ERROR", "unable to obtain postgres pid. please check your database server")
+				logging.LogCtx(ctx, "ERROR", "unable to obtain postgres pid. please check your database server")
 			}
 			var pid int
 			errPidQuery := pidQuery.Scan(pid)
 			if errPidQuery != nil {
 				processNotFound = "no pid"
-				logging.LogIt("existHandler", "ERROR", "unable to obtain postgres pid. pid query returned nil")
+				logging.LogCtx(ctx, "ERROR", "unable to obtain postgres pid. pid query returned nil")
 			}
-			logging.LogIt("existHandler", "INFO", "database found. (pid:"+strconv.Itoa(pid)+")")
-			logging.LogIt("existHandler", "ERROR", "postgres not found.")
+			logging.LogCtx(ctx, "INFO", "database found. (pid:"+strconv.Itoa(pid)+")")
+			logging.LogCtx(ctx, "ERROR", "postgres not found.")
 		} else {
-			result, initErr := InitDB() // TODO wrong result from here
+			result, initErr := InitDB(ctx) // TODO wrong result from here
 			if initErr != nil {
-				logging.LogIt("existHandler", "ERROR", "database initialization error after get request")
+				logging.LogCtx(ctx, "ERROR", "database initialization error after get request")
 			}
 			processNotFound = result
 		}
@@ -196,12 +283,17 @@ func CheckDB() (processNotFound string) {
 }
 
 // InsertCollectedData Insert the collected data from frontends into the DB
-func (collectedData *CollectionData) InsertCollectedData() error {
+func (collectedData *CollectionData) InsertCollectedData(ctx context.Context) error {
 	defer logging.TrackTime("InsertCollectedData", time.Now())
 	query := `
-INSERT INTO collect_table (frontend, backend, ip, port, path, method, xforwardfor, xrealip, useragent, via, age, timedate, cfipcountry)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);`
-	_, dbCheck := Db.Exec(query,
+INSERT INTO collect_table (frontend, backend, ip, port, path, method, xforwardfor, xrealip, useragent, via, age, timedate, cfipcountry, status)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);`
+	tx, dbCheck := beginCollect(ctx)
+	if dbCheck != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to begin transaction")
+		return dbCheck
+	}
+	_, dbCheck = tx.ExecContext(ctx, query,
 		collectedData.FrontendName,
 		Backend,
 		collectedData.IP,
@@ -215,76 +307,390 @@ VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);`
 		collectedData.Age,
 		collectedData.TimeDate,
 		collectedData.CFIPCountry,
+		collectedData.Status,
 	)
 	if dbCheck != nil {
-		logging.LogIt("insertCollectedData", "ERROR", "unable to insert data into database")
+		logging.LogCtx(ctx, "ERROR", "unable to insert data into database")
+		if errRollback := tx.Rollback(); errRollback != nil {
+			logging.LogCtx(ctx, "ERROR", "unable to rollback transaction")
+		}
+		return dbCheck
+	}
+	dbCheck = tx.Commit()
+	if dbCheck != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to commit transaction")
+		return dbCheck
 	}
+	recordIngest(1)
 	return nil
 }
 
-// BannedCheck Check the DB for the banned IP
-func (bannedData *BannedData) BannedCheck(ipAddress string) error {
+// beginCollect begins a transaction inserting into the collect table, holding CollectLockID shared until it ends so rollups do not
+// read a watermark above its uncommitted rows
+func beginCollect(ctx context.Context) (*sql.Tx, error) {
+	tx, err := Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock_shared($1);", CollectLockID)
+	if err != nil {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			logging.LogCtx(ctx, "ERROR", "unable to rollback transaction")
+		}
+		return nil, err
+	}
+	return tx, nil
+}
+
+// InsertCollectedBatch Insert a batch of collected data from frontends into the DB, using multi-row inserts within a single transaction
+func InsertCollectedBatch(ctx context.Context, batch []CollectionData) error {
+	defer logging.TrackTime("InsertCollectedBatch", time.Now())
+	if len(batch) == 0 {
+		return nil
+	}
+	tx, err := beginCollect(ctx)
+	if err != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to begin transaction")
+		return err
+	}
+	for start := 0; start < len(batch); start += InsertChunkSize {
+		end := start + InsertChunkSize
+		if end > len(batch) {
+			end = len(batch)
+		}
+		query, args := collectedBatchQuery(batch[start:end])
+		_, err = tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			logging.LogCtx(ctx, "ERROR", "unable to insert batch into database")
+			if errRollback := tx.Rollback(); errRollback != nil {
+				logging.LogCtx(ctx, "ERROR", "unable to rollback transaction")
+			}
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to commit batch transaction")
+		return err
+	}
+	recordIngest(len(batch))
+	return nil
+}
+
+// collectedBatchQuery builds a single multi-row INSERT statement, with its arguments, for the given records
+func collectedBatchQuery(batch []CollectionData) (string, []interface{}) {
+	const columns = 14
+	var query strings.Builder
+	query.WriteString("INSERT INTO " + CollectTable + " (frontend, backend, ip, port, path, method, xforwardfor, xrealip, useragent, via, age, timedate, cfipcountry, status) VALUES ")
+	args := make([]interface{}, 0, len(batch)*columns)
+	for i, collectedData := range batch {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for c := 1; c <= columns; c++ {
+			if c > 1 {
+				query.WriteString(", ")
+			}
+			query.WriteString("$" + strconv.Itoa(i*columns+c))
+		}
+		query.WriteString(")")
+		args = append(args,
+			collectedData.FrontendName,
+			Backend,
+			collectedData.IP,
+			collectedData.Port,
+			collectedData.Path,
+			collectedData.Method,
+			collectedData.XForwardFor,
+			collectedData.XRealIP,
+			collectedData.UserAgent,
+			collectedData.Via,
+			collectedData.Age,
+			collectedData.TimeDate,
+			collectedData.CFIPCountry,
+			collectedData.Status,
+		)
+	}
+	query.WriteString(";")
+	return query.String(), args
+}
+
+// bannedColumns columns selected by every ban query, in the order scanBan expects them
+const bannedColumns = `unique_id, ip, COALESCE(network::text, ip), COALESCE(domainname, ''), COALESCE(reason, ''), COALESCE(timechecked, 0), COALESCE(timebanned, 0), COALESCE(expires_at, 0)`
+
+// scanBan scans a single row, selected with bannedColumns, into bannedData
+func scanBan(row interface{ Scan(...interface{}) error }, bannedData *BannedData) error {
+	return row.Scan(
+		&bannedData.ID,
+		&bannedData.IP,
+		&bannedData.Prefix,
+		&bannedData.DomainName,
+		&bannedData.Reason,
+		&bannedData.TimeDateChecked,
+		&bannedData.TimeDateBanned,
+		&bannedData.ExpiresAt,
+	)
+}
+
+// BannedCheck Check the DB for a banned network containing the IP, preferring the most specific one. Bans past their expiry are treated as not banned
+func (bannedData *BannedData) BannedCheck(ctx context.Context, ipAddress string) error {
 	defer logging.TrackTime("BannedCheck", time.Now())
-	query := "SELECT * FROM " + BannedTable + " WHERE ip='$1';"
-	bannedRows, dbCheck := Db.Query(query, ipAddress)
+	addr, err := netip.ParseAddr(ipAddress)
+	if err != nil {
+		logging.LogCtx(ctx, "WARNING", "invalid ip address received: "+ipAddress)
+		return err
+	}
+	if cached, banned, ok := cachedBannedCheck(addr); ok {
+		*bannedData = cached
+		if !banned {
+			bannedData.IP = ipAddress
+		}
+		recordBanCheck(true, banned)
+		return nil
+	}
+	query := "SELECT " + bannedColumns + " FROM " + BannedTable + `
+WHERE network >>= $1::inet AND (expires_at IS NULL OR expires_at > $2)
+ORDER BY masklen(network) DESC, unique_id DESC
+LIMIT 1;`
+	dbCheck := scanBan(Db.QueryRowContext(ctx, query, addr.Unmap().WithZone("").String(), time.Now().Unix()), bannedData)
+	if errors.Is(dbCheck, sql.ErrNoRows) {
+		bannedData.IP = ipAddress
+		bannedData.Banned = false
+		recordBanCheck(false, false)
+		return nil
+	}
+	if dbCheck != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to query db")
+		return dbCheck
+	}
+	bannedData.Banned = true
+	recordBanCheck(false, true)
+	return nil
+}
+
+// SweepExpiredBans Move every ban past its expiry into the banned archive table. Returns the number of bans archived
+func SweepExpiredBans() (int64, error) {
+	defer logging.TrackTime("SweepExpiredBans", time.Now())
+	query := `
+WITH expired AS (
+	DELETE FROM ` + BannedTable + `
+	WHERE expires_at IS NOT NULL AND expires_at <= $1
+	RETURNING ip, network, domainname, reason, timechecked, timebanned, expires_at
+)
+INSERT INTO ` + BannedArchiveTable + ` (ip, network, domainname, reason, timechecked, timebanned, expires_at, timearchived)
+SELECT ip, network, domainname, reason, timechecked, timebanned, expires_at, $1 FROM expired;`
+	result, dbCheck := Db.Exec(query, time.Now().Unix())
+	if dbCheck != nil {
+		logging.LogIt("SweepExpiredBans", "ERROR", "unable to archive expired bans")
+		return 0, dbCheck
+	}
+	archived, err := result.RowsAffected()
+	if err != nil {
+		logging.LogIt("SweepExpiredBans", "ERROR", "unable to count archived bans")
+		return 0, err
+	}
+	if archived > 0 {
+		reloadBanCache()
+	}
+	return archived, nil
+}
+
+// StartBanSweeper Archive expired bans every interval, in the background, until ctx is cancelled
+func StartBanSweeper(ctx context.Context, interval time.Duration) {
+	Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				archived, err := SweepExpiredBans()
+				if err != nil {
+					logging.LogIt("StartBanSweeper", "ERROR", "sweep failed: "+fmt.Sprintln(err))
+					continue
+				}
+				if archived > 0 {
+					logging.LogIt("StartBanSweeper", "INFO", strconv.FormatInt(archived, 10)+" expired bans archived")
+				}
+			}
+		}
+	})
+}
+
+// InsertBan Insert a new ban into the DB, filling in its ID and time of banning. IP may be a single address or a CIDR network
+func (bannedData *BannedData) InsertBan(ctx context.Context) error {
+	defer logging.TrackTime("InsertBan", time.Now())
+	bannedData.TimeDateBanned = time.Now().Unix()
+	query := `
+INSERT INTO ` + BannedTable + ` (ip, network, domainname, reason, timechecked, timebanned, expires_at)
+VALUES ($1, network($1::inet), $2, $3, $4, $5, NULLIF($6, 0))
+RETURNING unique_id, network::text;`
+	dbCheck := Db.QueryRowContext(ctx, query,
+		bannedData.IP,
+		bannedData.DomainName,
+		bannedData.Reason,
+		bannedData.TimeDateChecked,
+		bannedData.TimeDateBanned,
+		bannedData.ExpiresAt,
+	).Scan(&bannedData.ID, &bannedData.Prefix)
+	if dbCheck != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to insert ban into database")
+		return dbCheck
+	}
+	bannedData.Banned = true
+	reloadBanCache()
+	return nil
+}
+
+// DeleteBan Lift every ban on exactly this address or network, limited to a single domain name if one is given. Returns the number of bans lifted
+func DeleteBan(ctx context.Context, network string, domainName string) (int64, error) {
+	defer logging.TrackTime("DeleteBan", time.Now())
+	query := "DELETE FROM " + BannedTable + " WHERE network=network($1::inet) AND ($2 = '' OR domainname=$2);"
+	result, dbCheck := Db.ExecContext(ctx, query, network, domainName)
 	if dbCheck != nil {
-		logging.LogIt("bannedCheck", "ERROR", "unable to query db")
+		logging.LogCtx(ctx, "ERROR", "unable to delete ban from database")
+		return 0, dbCheck
+	}
+	lifted, err := result.RowsAffected()
+	if err != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to count lifted bans")
+		return 0, err
+	}
+	if lifted > 0 {
+		reloadBanCache()
+	}
+	return lifted, nil
+}
+
+// ListBans Fetch a single page of bans, newest first, along with the total number of bans
+func ListBans(ctx context.Context, page, limit int) (BannedList, error) {
+	defer logging.TrackTime("ListBans", time.Now())
+	bannedList := BannedList{Page: page, Limit: limit, Bans: []BannedData{}}
+	dbCheck := Db.QueryRowContext(ctx, "SELECT count(*) FROM "+BannedTable+";").Scan(&bannedList.Total)
+	if dbCheck != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to count bans")
+		return bannedList, dbCheck
+	}
+	query := "SELECT " + bannedColumns + " FROM " + BannedTable + `
+ORDER BY unique_id DESC
+LIMIT $1 OFFSET $2;`
+	rows, dbCheck := Db.QueryContext(ctx, query, limit, (page-1)*limit)
+	if dbCheck != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to query db")
+		return bannedList, dbCheck
 	}
 	defer func() {
-		errClose := bannedRows.Close()
+		errClose := rows.Close()
 		if errClose != nil {
-			logging.LogIt("bannedCheck", "ERROR", "error closing query")
+			logging.LogCtx(ctx, "ERROR", "error closing query")
 		}
 	}()
-
-	for bannedRows.Next() {
-		errRows := bannedRows.Scan(
-			&bannedData.IP,
-			&bannedData.TimeDateBanned,
-			&bannedData.TimeDateChecked,
-			&bannedData.DomainName,
-			&bannedData.Banned,
-		)
+	for rows.Next() {
+		bannedData := BannedData{Banned: true}
+		errRows := scanBan(rows, &bannedData)
 		if errRows != nil {
-			logging.LogIt("bannedCheck", "ERROR", "unable to scan rows")
+			logging.LogCtx(ctx, "ERROR", "unable to scan rows")
+			return bannedList, errRows
 		}
+		bannedList.Bans = append(bannedList.Bans, bannedData)
 	}
-	return nil
+	return bannedList, rows.Err()
+}
+
+// collectedColumns columns selected by every collected data query, in the order scanCollected expects them
+const collectedColumns = `unique_id, COALESCE(frontend, ''), COALESCE(ip, ''), COALESCE(port, 0), COALESCE(path, ''), COALESCE(method, ''),
+	COALESCE(xforwardfor, ''), COALESCE(xrealip, ''), COALESCE(useragent, ''), COALESCE(via, ''), COALESCE(age, ''),
+	COALESCE(timedate, 0), COALESCE(cfipcountry, ''), COALESCE(status, 0)`
+
+// scanCollected scans a single row, selected with collectedColumns, into collectedData
+func scanCollected(row interface{ Scan(...interface{}) error }, collectedData *CollectionData) error {
+	return row.Scan(
+		&collectedData.ID,
+		&collectedData.FrontendName,
+		&collectedData.IP,
+		&collectedData.Port,
+		&collectedData.Path,
+		&collectedData.Method,
+		&collectedData.XForwardFor,
+		&collectedData.XRealIP,
+		&collectedData.UserAgent,
+		&collectedData.Via,
+		&collectedData.Age,
+		&collectedData.TimeDate,
+		&collectedData.CFIPCountry,
+		&collectedData.Status,
+	)
 }
 
-// FetchAll Fetch all collected data
-func (collectedData *CollectionData) FetchAll() error {
-	defer logging.TrackTime("FetchAll", time.Now())
-	query := "select * from collect_data;"
-	rows, dbCheck := Db.Query(query)
+// FetchCollected Fetch a single page of collected data matching the filter, newest first. NextCursor is set when more rows follow
+func FetchCollected(ctx context.Context, filter CollectedFilter) (CollectedPage, error) {
+	defer logging.TrackTime("FetchCollected", time.Now())
+	collectedPage := CollectedPage{Data: []CollectionData{}}
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if filter.Frontend != "" {
+		addCondition("frontend = ?", filter.Frontend)
+	}
+	if filter.IP != "" {
+		addCondition("ip = ?", filter.IP)
+	}
+	if filter.Path != "" {
+		addCondition("path = ?", filter.Path)
+	}
+	if filter.Method != "" {
+		addCondition("method = ?", filter.Method)
+	}
+	if filter.Country != "" {
+		addCondition("cfipcountry = ?", filter.Country)
+	}
+	if filter.From != 0 {
+		addCondition("timedate >= ?", filter.From)
+	}
+	if filter.To != 0 {
+		addCondition("timedate < ?", filter.To)
+	}
+	if filter.Cursor != 0 {
+		addCondition("unique_id < ?", filter.Cursor)
+	}
+	query := "SELECT " + collectedColumns + " FROM " + CollectTable
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// one extra row tells us whether there is a next page
+	args = append(args, filter.Limit+1)
+	query += " ORDER BY unique_id DESC LIMIT $" + strconv.Itoa(len(args)) + ";"
+	rows, dbCheck := Db.QueryContext(ctx, query, args...)
 	if dbCheck != nil {
-		logging.LogIt("bannedCheck", "ERROR", "unable to query db")
+		logging.LogCtx(ctx, "ERROR", "unable to query db")
+		return collectedPage, dbCheck
 	}
 	defer func() {
 		errClose := rows.Close()
 		if errClose != nil {
-			logging.LogIt("bannedCheck", "ERROR", "error closing query")
+			logging.LogCtx(ctx, "ERROR", "error closing query")
 		}
 	}()
-	// TODO  plonk everything into a slice, rather than write it to a singular struct
 	for rows.Next() {
-		errRows := rows.Scan(
-			&collectedData.FrontendName,
-			&collectedData.IP,
-			&collectedData.Port,
-			&collectedData.Path,
-			&collectedData.Method,
-			&collectedData.XForwardFor,
-			&collectedData.XRealIP,
-			&collectedData.UserAgent,
-			&collectedData.Via,
-			&collectedData.Age,
-			&collectedData.TimeDate,
-			&collectedData.CFIPCountry,
-		)
+		var collectedData CollectionData
+		errRows := scanCollected(rows, &collectedData)
 		if errRows != nil {
-			logging.LogIt("bannedCheck", "ERROR", "unable to scan rows")
+			logging.LogCtx(ctx, "ERROR", "unable to scan rows")
+			return collectedPage, errRows
 		}
+		collectedPage.Data = append(collectedPage.Data, collectedData)
 	}
-	return nil
+	if err := rows.Err(); err != nil {
+		return collectedPage, err
+	}
+	if len(collectedPage.Data) > filter.Limit {
+		collectedPage.Data = collectedPage.Data[:filter.Limit]
+		collectedPage.NextCursor = collectedPage.Data[filter.Limit-1].ID
+	}
+	return collectedPage, nil
 }
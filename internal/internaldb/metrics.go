@@ -0,0 +1,63 @@
+package internaldb
+
+import (
+	"database/sql"
+	"time"
+	"zehd-backend/internal/metrics"
+
+	. "zehd-backend/internal"
+)
+
+var (
+	ingestedRows = metrics.NewCounterVec("zehd_ingested_rows_total", "Rows of collected data written")
+	banChecks    = metrics.NewCounterVec("zehd_ban_checks_total", "Ban checks, by where they were answered from (cache or database) and result (banned or clear)",
+		"source", "result")
+)
+
+func init() {
+	poolStat := func(stat func(sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			if Db == nil {
+				return 0
+			}
+			return stat(Db.Stats())
+		}
+	}
+	metrics.NewGaugeFunc("zehd_db_max_open_connections", "Most connections the pool opens",
+		poolStat(func(stats sql.DBStats) float64 { return float64(stats.MaxOpenConnections) }))
+	metrics.NewGaugeFunc("zehd_db_open_connections", "Connections open, in use or idle",
+		poolStat(func(stats sql.DBStats) float64 { return float64(stats.OpenConnections) }))
+	metrics.NewGaugeFunc("zehd_db_in_use_connections", "Connections in use",
+		poolStat(func(stats sql.DBStats) float64 { return float64(stats.InUse) }))
+	metrics.NewGaugeFunc("zehd_db_idle_connections", "Idle connections",
+		poolStat(func(stats sql.DBStats) float64 { return float64(stats.Idle) }))
+	metrics.NewCounterFunc("zehd_db_wait_count_total", "Times a query waited for a connection",
+		poolStat(func(stats sql.DBStats) float64 { return float64(stats.WaitCount) }))
+	metrics.NewCounterFunc("zehd_db_wait_duration_seconds_total", "Time spent waiting for connections",
+		poolStat(func(stats sql.DBStats) float64 { return stats.WaitDuration.Seconds() }))
+	metrics.NewGaugeFunc("zehd_db_available", "Whether the database answered the last check",
+		func() float64 {
+			if Available() {
+				return 1
+			}
+			return 0
+		})
+}
+
+// recordIngest notes that rows of collected data were just written
+func recordIngest(rows int) {
+	lastIngest.Store(time.Now().Unix())
+	ingestedRows.Add(float64(rows))
+}
+
+// recordBanCheck counts a ban check, answered from the cache or the database
+func recordBanCheck(fromCache bool, banned bool) {
+	source, result := "database", "clear"
+	if fromCache {
+		source = "cache"
+	}
+	if banned {
+		result = "banned"
+	}
+	banChecks.Inc(source, result)
+}
@@ -0,0 +1,292 @@
+package internaldb
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration A single schema change, read from migrations/<version>_<name>.up.sql and its .down.sql counterpart
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus A migration and whether, and when, it was applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt int64
+}
+
+// how long to wait before retrying the migration lock while another backend holds it, doubling up to migrationLockRetryMax
+const (
+	migrationLockRetry    = 100 * time.Millisecond
+	migrationLockRetryMax = 5 * time.Second
+)
+
+// migrationFileName matches migration files, e.g. 0002_ban_reason_expiry.up.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migrations The migrations embedded in the binary, in order
+func Migrations() ([]Migration, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return ParseMigrations(sub)
+}
+
+// ParseMigrations Read every migration in the root of fsys, in order. Versions must be unique, start at 1 without gaps and have both an up and a down file
+func ParseMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, errRead := fs.ReadFile(fsys, entry.Name())
+		if errRead != nil {
+			return nil, errRead
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs both an up and a down file", migration.Version, migration.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// MigrateUp Apply every migration that has not been applied yet, giving up once ctx is cancelled. Returns the number of migrations applied
+func MigrateUp(ctx context.Context) (int, error) {
+	defer logging.TrackTime("MigrateUp", time.Now())
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	err = withMigrationLock(ctx, func(conn *sql.Conn, current int) error {
+		for _, migration := range migrations {
+			if migration.Version <= current {
+				continue
+			}
+			errMigration := runMigration(ctx, conn, migration.Up,
+				"INSERT INTO "+SchemaMigrationsTable+" (version, name, applied_at) VALUES ($1, $2, $3);",
+				migration.Version, migration.Name, time.Now().Unix())
+			if errMigration != nil {
+				logging.LogIt("MigrateUp", "ERROR", "unable to apply migration "+strconv.Itoa(migration.Version)+" ("+migration.Name+")")
+				return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, errMigration)
+			}
+			logging.LogIt("MigrateUp", "INFO", "applied migration "+strconv.Itoa(migration.Version)+" ("+migration.Name+")")
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown Revert the last steps migrations that were applied, giving up once ctx is cancelled. Returns the number of migrations reverted
+func MigrateDown(ctx context.Context, steps int) (int, error) {
+	defer logging.TrackTime("MigrateDown", time.Now())
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	reverted := 0
+	err = withMigrationLock(ctx, func(conn *sql.Conn, current int) error {
+		for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := migrations[i]
+			if migration.Version > current {
+				continue
+			}
+			errMigration := runMigration(ctx, conn, migration.Down,
+				"DELETE FROM "+SchemaMigrationsTable+" WHERE version = $1;", migration.Version)
+			if errMigration != nil {
+				logging.LogIt("MigrateDown", "ERROR", "unable to revert migration "+strconv.Itoa(migration.Version)+" ("+migration.Name+")")
+				return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, errMigration)
+			}
+			logging.LogIt("MigrateDown", "INFO", "reverted migration "+strconv.Itoa(migration.Version)+" ("+migration.Name+")")
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatuses Every known migration and whether it has been applied
+func MigrationStatuses() ([]MigrationStatus, error) {
+	defer logging.TrackTime("MigrationStatuses", time.Now())
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	appliedAt, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		at, applied := appliedAt[migration.Version]
+		statuses[i] = MigrationStatus{Migration: migration, Applied: applied, AppliedAt: at}
+	}
+	return statuses, nil
+}
+
+// SchemaVersion The newest migration applied to the database, and the newest migration embedded in the binary
+func SchemaVersion() (current int, latest int, err error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+	err = Db.QueryRow("SELECT COALESCE(max(version), 0) FROM " + SchemaMigrationsTable + ";").Scan(&current)
+	return current, latest, err
+}
+
+// appliedMigrations returns when each applied migration was applied, by version. An absent migrations table means none were applied
+func appliedMigrations() (map[int]int64, error) {
+	var exists bool
+	err := Db.QueryRow("SELECT to_regclass($1) IS NOT NULL;", SchemaMigrationsTable).Scan(&exists)
+	if err != nil || !exists {
+		return map[int]int64{}, err
+	}
+	rows, err := Db.Query("SELECT version, applied_at FROM " + SchemaMigrationsTable + ";")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		errClose := rows.Close()
+		if errClose != nil {
+			logging.LogIt("appliedMigrations", "ERROR", "error closing query")
+		}
+	}()
+	appliedAt := make(map[int]int64)
+	for rows.Next() {
+		var version int
+		var at int64
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	return appliedAt, rows.Err()
+}
+
+// withMigrationLock runs migrate on a single connection holding the migration advisory lock, so concurrently starting backends
+// migrate one after the other, passing it the current schema version. While another backend holds the lock, it is retried with
+// backoff until ctx is cancelled
+func withMigrationLock(ctx context.Context, migrate func(conn *sql.Conn, current int) error) error {
+	if Db == nil {
+		return errors.New("database is not connected")
+	}
+	conn, err := Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		errClose := conn.Close()
+		if errClose != nil {
+			logging.LogIt("withMigrationLock", "ERROR", "unable to release connection")
+		}
+	}()
+	delay := migrationLockRetry
+	for {
+		var locked bool
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1);", MigrationLockID).Scan(&locked)
+		if err != nil {
+			logging.LogIt("withMigrationLock", "ERROR", "unable to acquire migration lock")
+			return err
+		}
+		if locked {
+			break
+		}
+		logging.LogIt("withMigrationLock", "INFO", "waiting for another backend to finish migrating, retrying in "+delay.String())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = nextBackoff(delay, migrationLockRetryMax)
+	}
+	defer func() {
+		// the lock belongs to the pooled connection's session, so it is released even once ctx is cancelled
+		_, errUnlock := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1);", MigrationLockID)
+		if errUnlock != nil {
+			logging.LogIt("withMigrationLock", "ERROR", "unable to release migration lock")
+		}
+	}()
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+SchemaMigrationsTable+"("+SchemaMigrationsTableColumns+");")
+	if err != nil {
+		logging.LogIt("withMigrationLock", "ERROR", "unable to create table ("+SchemaMigrationsTable+").")
+		return err
+	}
+	var current int
+	err = conn.QueryRowContext(ctx, "SELECT COALESCE(max(version), 0) FROM "+SchemaMigrationsTable+";").Scan(&current)
+	if err != nil {
+		return err
+	}
+	return migrate(conn, current)
+}
+
+// runMigration runs the migration script and the schema_migrations bookkeeping in a single transaction
+func runMigration(ctx context.Context, conn *sql.Conn, script string, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, script)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, bookkeeping, args...)
+	}
+	if err != nil {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			logging.LogIt("runMigration", "ERROR", "unable to rollback transaction")
+		}
+		return err
+	}
+	return tx.Commit()
+}
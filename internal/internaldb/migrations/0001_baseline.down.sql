@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS banned_table;
+DROP TABLE IF EXISTS checked_table;
+DROP TABLE IF EXISTS collect_table;
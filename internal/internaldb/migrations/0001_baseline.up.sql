@@ -0,0 +1,37 @@
+-- the tables created by InitDB before migrations existed, so existing databases are adopted as they are
+CREATE TABLE IF NOT EXISTS collect_table (
+	unique_id SERIAL NOT NULL,
+	frontend TEXT,
+	backend TEXT,
+	ip TEXT,
+	port INT,
+	path TEXT,
+	method TEXT,
+	xforwardfor TEXT,
+	xrealip TEXT,
+	useragent TEXT,
+	via TEXT,
+	age TEXT,
+	timedate BIGINT,
+	checked BOOL,
+	banned BOOL,
+	cfipcountry TEXT,
+	PRIMARY KEY (unique_id)
+);
+
+CREATE TABLE IF NOT EXISTS checked_table (
+	unique_id SERIAL NOT NULL,
+	ip TEXT,
+	domainname TEXT,
+	timechecked BIGINT,
+	PRIMARY KEY (unique_id)
+);
+
+CREATE TABLE IF NOT EXISTS banned_table (
+	unique_id SERIAL NOT NULL,
+	ip TEXT,
+	domainname TEXT,
+	timechecked BIGINT,
+	timebanned BIGINT,
+	PRIMARY KEY (unique_id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE banned_table DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE banned_table DROP COLUMN IF EXISTS reason;
@@ -0,0 +1,2 @@
+ALTER TABLE banned_table ADD COLUMN IF NOT EXISTS reason TEXT;
+ALTER TABLE banned_table ADD COLUMN IF NOT EXISTS expires_at BIGINT;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS banned_archive_table (
+	unique_id SERIAL NOT NULL,
+	ip TEXT,
+	domainname TEXT,
+	reason TEXT,
+	timechecked BIGINT,
+	timebanned BIGINT,
+	expires_at BIGINT,
+	timearchived BIGINT,
+	PRIMARY KEY (unique_id)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS banned_table_network_idx;
+ALTER TABLE banned_archive_table DROP COLUMN IF EXISTS network;
+ALTER TABLE banned_table DROP COLUMN IF EXISTS network;
@@ -0,0 +1,5 @@
+ALTER TABLE banned_table ADD COLUMN IF NOT EXISTS network CIDR;
+ALTER TABLE banned_archive_table ADD COLUMN IF NOT EXISTS network CIDR;
+-- bans created before networks were supported only have their ip filled in
+UPDATE banned_table SET network = network(ip::inet) WHERE network IS NULL AND ip ~ '^[0-9A-Fa-f:.]+(/[0-9]+)?$';
+CREATE INDEX IF NOT EXISTS banned_table_network_idx ON banned_table USING gist (network inet_ops);
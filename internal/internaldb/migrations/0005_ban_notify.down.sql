@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS banned_table_changed ON banned_table;
+DROP FUNCTION IF EXISTS notify_banned_table_changed();
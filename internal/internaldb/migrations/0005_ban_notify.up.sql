@@ -0,0 +1,10 @@
+CREATE OR REPLACE FUNCTION notify_banned_table_changed() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('banned_table_changed', '');
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS banned_table_changed ON banned_table;
+CREATE TRIGGER banned_table_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON banned_table
+	FOR EACH STATEMENT EXECUTE PROCEDURE notify_banned_table_changed();
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS collect_table_unchecked_idx;
+DROP INDEX IF EXISTS collect_table_timedate_idx;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS collect_table_timedate_idx ON collect_table (timedate);
+CREATE INDEX IF NOT EXISTS collect_table_unchecked_idx ON collect_table (unique_id) WHERE checked IS NOT TRUE;
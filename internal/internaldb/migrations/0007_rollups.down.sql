@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS rollup_state;
+DROP TABLE IF EXISTS collect_rollup_daily;
+DROP TABLE IF EXISTS collect_rollup_hourly;
+ALTER TABLE collect_table DROP COLUMN IF EXISTS status;
@@ -0,0 +1,27 @@
+ALTER TABLE collect_table ADD COLUMN IF NOT EXISTS status INT;
+
+CREATE TABLE IF NOT EXISTS collect_rollup_hourly (
+	bucket BIGINT NOT NULL,
+	frontend TEXT NOT NULL,
+	path TEXT NOT NULL,
+	cfipcountry TEXT NOT NULL,
+	status INT NOT NULL,
+	hits BIGINT NOT NULL,
+	PRIMARY KEY (bucket, frontend, path, cfipcountry, status)
+);
+
+CREATE TABLE IF NOT EXISTS collect_rollup_daily (
+	bucket BIGINT NOT NULL,
+	frontend TEXT NOT NULL,
+	path TEXT NOT NULL,
+	cfipcountry TEXT NOT NULL,
+	status INT NOT NULL,
+	hits BIGINT NOT NULL,
+	PRIMARY KEY (bucket, frontend, path, cfipcountry, status)
+);
+
+CREATE TABLE IF NOT EXISTS rollup_state (
+	name TEXT NOT NULL,
+	last_id BIGINT NOT NULL,
+	PRIMARY KEY (name)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS frontend_table (
+	name TEXT NOT NULL,
+	api_key TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	PRIMARY KEY (name)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE frontend_table DROP COLUMN IF EXISTS connection;
+ALTER TABLE frontend_table DROP COLUMN IF EXISTS version;
+ALTER TABLE frontend_table DROP COLUMN IF EXISTS last_seen;
+DELETE FROM frontend_table WHERE api_key IS NULL;
+ALTER TABLE frontend_table ALTER COLUMN api_key SET NOT NULL;
@@ -0,0 +1,5 @@
+-- frontends register themselves with their first heartbeat, before being given an API key
+ALTER TABLE frontend_table ALTER COLUMN api_key DROP NOT NULL;
+ALTER TABLE frontend_table ADD COLUMN IF NOT EXISTS last_seen BIGINT;
+ALTER TABLE frontend_table ADD COLUMN IF NOT EXISTS version TEXT;
+ALTER TABLE frontend_table ADD COLUMN IF NOT EXISTS connection TEXT;
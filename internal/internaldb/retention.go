@@ -0,0 +1,274 @@
+package internaldb
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+// RetentionPolicy How long collected data is kept. A zero duration keeps that data forever
+type RetentionPolicy struct {
+	// Raw how long rows in the collect table are kept. Rows are only pruned once they have been rolled up
+	Raw time.Duration
+	// Rollups how long rows in the rollup tables are kept
+	Rollups time.Duration
+	// ArchiveDir if set, pruned rows are written to gzipped NDJSON files in this directory before they are deleted
+	ArchiveDir string
+	// DryRun only counts the rows that would be pruned
+	DryRun bool
+}
+
+// Enabled Reports whether the policy prunes anything at all
+func (policy RetentionPolicy) Enabled() bool {
+	return policy.Raw > 0 || policy.Rollups > 0
+}
+
+// RetentionReport What was, or in a dry run would be, pruned from a single table
+type RetentionReport struct {
+	Table   string `json:"table"`
+	Cutoff  int64  `json:"cutoff"`
+	Rows    int64  `json:"rows"`
+	Archive string `json:"archive,omitempty"`
+	DryRun  bool   `json:"dryRun"`
+}
+
+func (report RetentionReport) String() string {
+	action := "pruned"
+	if report.DryRun {
+		action = "would be pruned"
+	}
+	message := strconv.FormatInt(report.Rows, 10) + " rows older than " + time.Unix(report.Cutoff, 0).UTC().Format(time.RFC3339) + " " + action + " from " + report.Table
+	if report.Archive != "" {
+		message += ", archived to " + report.Archive
+	}
+	return message
+}
+
+// ApplyRetention Prune, optionally archiving, every table the policy covers, until ctx is cancelled. Rows are pruned PruneChunkSize
+// at a time, each chunk in its own transaction
+func ApplyRetention(ctx context.Context, policy RetentionPolicy) ([]RetentionReport, error) {
+	defer logging.TrackTime("ApplyRetention", time.Now())
+	now := time.Now()
+	var reports []RetentionReport
+	if policy.Raw > 0 {
+		// rows that are not rolled up yet are kept, so pruning never loses hits from the rollup tables
+		condition := "timedate < $1 AND unique_id <= COALESCE((SELECT last_id FROM " + RollupStateTable + " WHERE name = '" + rollupStateName + "'), 0)"
+		report, err := pruneTable(ctx, CollectTable, "unique_id", condition, now.Add(-policy.Raw).Unix(), policy)
+		reports = append(reports, report)
+		if err != nil {
+			return reports, err
+		}
+	}
+	if policy.Rollups > 0 {
+		for _, rollup := range rollups {
+			// rollup rows have no ID of their own, their physical row ID picks out a chunk just as well
+			report, err := pruneTable(ctx, rollup.table, "ctid", "bucket < $1", now.Add(-policy.Rollups).Unix(), policy)
+			reports = append(reports, report)
+			if err != nil {
+				return reports, err
+			}
+		}
+	}
+	return reports, nil
+}
+
+// pruneTable counts, or archives and deletes, the table's rows matching condition, where $1 is the cutoff. Rows are deleted in chunks
+// picked by key, each chunk archived from the rows its DELETE returns before it commits, so rows are never deleted without being
+// archived, though the rows of a chunk failing to commit are archived again by the next run. The report covers the chunks pruned
+// before any error
+func pruneTable(ctx context.Context, table, key, condition string, cutoff int64, policy RetentionPolicy) (RetentionReport, error) {
+	report := RetentionReport{Table: table, Cutoff: cutoff, DryRun: policy.DryRun}
+	if policy.DryRun {
+		err := Db.QueryRowContext(ctx, "SELECT count(*) FROM "+table+" WHERE "+condition+";", cutoff).Scan(&report.Rows)
+		if err != nil {
+			logging.LogIt("pruneTable", "ERROR", "unable to count rows to prune from "+table)
+		}
+		return report, err
+	}
+	query := "DELETE FROM " + table + " WHERE " + key + " = ANY(ARRAY(SELECT " + key + " FROM " + table + " WHERE " + condition +
+		" LIMIT " + strconv.Itoa(PruneChunkSize) + "))"
+	var archive *archiveFile
+	if policy.ArchiveDir != "" {
+		query += " RETURNING *"
+		archive = &archiveFile{path: filepath.Join(policy.ArchiveDir, table+"-"+strconv.FormatInt(cutoff, 10)+"-"+strconv.FormatInt(time.Now().Unix(), 10)+".ndjson.gz")}
+	}
+	err := ctx.Err()
+	for err == nil {
+		var pruned int64
+		pruned, err = pruneChunk(ctx, query+";", cutoff, archive)
+		report.Rows += pruned
+		if err != nil || pruned < PruneChunkSize {
+			break
+		}
+		err = ctx.Err()
+	}
+	if archive != nil {
+		archived, errArchive := archive.close()
+		if err == nil {
+			err = errArchive
+		}
+		if archived > 0 {
+			report.Archive = archive.path
+		}
+	}
+	if err != nil {
+		logging.LogIt("pruneTable", "ERROR", "unable to prune rows from "+table)
+	}
+	return report, err
+}
+
+// pruneChunk deletes a single chunk with query in its own transaction, archiving the rows it returns when archive is set. Returns the
+// number of rows deleted
+func pruneChunk(ctx context.Context, query string, cutoff int64, archive *archiveFile) (int64, error) {
+	tx, err := Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	rollback := func(err error) (int64, error) {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			logging.LogIt("pruneChunk", "ERROR", "unable to rollback transaction")
+		}
+		return 0, err
+	}
+	var pruned int64
+	if archive == nil {
+		result, errDelete := tx.ExecContext(ctx, query, cutoff)
+		if errDelete != nil {
+			return rollback(errDelete)
+		}
+		pruned, err = result.RowsAffected()
+	} else {
+		rows, errDelete := tx.QueryContext(ctx, query, cutoff)
+		if errDelete != nil {
+			return rollback(errDelete)
+		}
+		pruned, err = archive.write(rows)
+	}
+	if err != nil {
+		return rollback(err)
+	}
+	return pruned, tx.Commit()
+}
+
+// archiveFile a gzipped NDJSON file pruned rows are written to, one JSON object per row keyed by column name. It is only created once
+// there is a row to write
+type archiveFile struct {
+	path     string
+	file     *os.File
+	gzip     *gzip.Writer
+	archived int64
+}
+
+// write encodes every row and flushes them to the file, closing rows. Returns the number of rows written
+func (archive *archiveFile) write(rows *sql.Rows) (int64, error) {
+	defer func() {
+		errClose := rows.Close()
+		if errClose != nil {
+			logging.LogIt("archiveFile", "ERROR", "error closing query")
+		}
+	}()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	var written int64
+	for rows.Next() {
+		if archive.file == nil {
+			if err = archive.open(); err != nil {
+				return written, err
+			}
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return written, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			row[column] = values[i]
+		}
+		if err = json.NewEncoder(archive.gzip).Encode(row); err != nil {
+			return written, fmt.Errorf("unable to write archive %s: %w", archive.path, err)
+		}
+		written++
+	}
+	if err = rows.Err(); err != nil {
+		return written, err
+	}
+	if archive.gzip != nil {
+		if err = archive.gzip.Flush(); err != nil {
+			return written, fmt.Errorf("unable to write archive %s: %w", archive.path, err)
+		}
+	}
+	archive.archived += written
+	return written, nil
+}
+
+func (archive *archiveFile) open() error {
+	err := os.MkdirAll(filepath.Dir(archive.path), 0750)
+	if err != nil {
+		return err
+	}
+	archive.file, err = os.OpenFile(archive.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	archive.gzip = gzip.NewWriter(archive.file)
+	return nil
+}
+
+// close finishes the file, removing it again if no row was written to it. Returns the number of rows archived
+func (archive *archiveFile) close() (int64, error) {
+	if archive.file == nil {
+		return 0, nil
+	}
+	err := archive.gzip.Close()
+	if errClose := archive.file.Close(); err == nil {
+		err = errClose
+	}
+	if archive.archived == 0 {
+		if errRemove := os.Remove(archive.path); errRemove != nil {
+			logging.LogIt("archiveFile", "ERROR", "unable to remove archive "+archive.path)
+		}
+	}
+	if err != nil {
+		return archive.archived, fmt.Errorf("unable to write archive %s: %w", archive.path, err)
+	}
+	return archive.archived, nil
+}
+
+// StartRetention Apply the retention policy every interval, in the background, until ctx is cancelled
+func StartRetention(ctx context.Context, policy RetentionPolicy, interval time.Duration) {
+	Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reports, err := ApplyRetention(ctx, policy)
+				for _, report := range reports {
+					if report.Rows > 0 {
+						logging.LogIt("StartRetention", "INFO", report.String())
+					}
+				}
+				if err != nil {
+					logging.LogIt("StartRetention", "ERROR", "retention failed: "+fmt.Sprintln(err))
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package internaldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+// rollups every rollup table and the width, in seconds, of its buckets
+var rollups = []struct {
+	table  string
+	bucket int64
+}{
+	{HourlyRollupTable, 3600},
+	{DailyRollupTable, 86400},
+}
+
+// rollupStateName the RollupStateTable row tracking CollectTable
+const rollupStateName = CollectTable
+
+// rollupSettleTimeout how long a rollup waits for the inserts that may still commit rows below its watermark
+const rollupSettleTimeout = 30 * time.Second
+
+// UpdateRollups Add every collected row not rolled up yet to the rollup tables. Returns the number of rows rolled up
+func UpdateRollups(ctx context.Context) (int64, error) {
+	defer logging.TrackTime("UpdateRollups", time.Now())
+	return rollUp(ctx, false)
+}
+
+// BackfillRollups Rebuild the rollup tables from the rows in the collect table, keeping the buckets older than its first whole bucket,
+// whose rows may have been pruned. Returns the number of rows rolled up
+func BackfillRollups(ctx context.Context) (int64, error) {
+	defer logging.TrackTime("BackfillRollups", time.Now())
+	return rollUp(ctx, true)
+}
+
+// watermark returns the highest collected row ID safe to roll up. IDs are handed out before commit, so a batch committing after a
+// newer row would otherwise be overtaken and never rolled up. Inserts hold CollectLockID shared until they commit, so once it is held
+// exclusively every ID handed out so far is committed, while new inserts wait for it. ok is false when inserts held it for longer than
+// rollupSettleTimeout
+func watermark(ctx context.Context) (id int64, ok bool, err error) {
+	tx, err := Db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.LogIt("watermark", "ERROR", "unable to begin transaction")
+		return 0, false, err
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, rollupSettleTimeout)
+	defer cancel()
+	_, err = tx.ExecContext(lockCtx, "SELECT pg_advisory_xact_lock($1);", CollectLockID)
+	if err != nil {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			logging.LogIt("watermark", "ERROR", "unable to rollback transaction")
+		}
+		if ctx.Err() == nil && lockCtx.Err() != nil {
+			logging.LogIt("watermark", "WARNING", "rollup held back by inserts still running after "+rollupSettleTimeout.String())
+			return 0, false, nil
+		}
+		logging.LogIt("watermark", "ERROR", "unable to take the collect lock")
+		return 0, false, err
+	}
+	var lastValue sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT pg_sequence_last_value(pg_get_serial_sequence($1, 'unique_id'));", CollectTable).Scan(&lastValue)
+	if err != nil {
+		logging.LogIt("watermark", "ERROR", "unable to read the collected row ID sequence")
+		if errRollback := tx.Rollback(); errRollback != nil {
+			logging.LogIt("watermark", "ERROR", "unable to rollback transaction")
+		}
+		return 0, false, err
+	}
+	// committing releases the lock, letting waiting inserts through
+	return lastValue.Int64, true, tx.Commit()
+}
+
+// rollUp aggregates the rows between the last rolled up row and the watermark, in a single transaction, optionally rebuilding every
+// bucket from the first whole one still in the collect table. The watermark is recorded as the last rolled up row
+func rollUp(ctx context.Context, rebuild bool) (int64, error) {
+	safeID, ok, err := watermark(ctx)
+	if err != nil || !ok {
+		return 0, err
+	}
+	tx, err := Db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.LogIt("rollUp", "ERROR", "unable to begin transaction")
+		return 0, err
+	}
+	rollback := func(message string, err error) (int64, error) {
+		logging.LogIt("rollUp", "ERROR", message)
+		if errRollback := tx.Rollback(); errRollback != nil {
+			logging.LogIt("rollUp", "ERROR", "unable to rollback transaction")
+		}
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+RollupStateTable+" (name, last_id) VALUES ($1, 0) ON CONFLICT (name) DO NOTHING;", rollupStateName)
+	if err != nil {
+		return rollback("unable to initialize rollup state", err)
+	}
+	// locking the state row keeps concurrent backends from rolling up the same rows twice
+	var lastID int64
+	err = tx.QueryRowContext(ctx, "SELECT last_id FROM "+RollupStateTable+" WHERE name = $1 FOR UPDATE;", rollupStateName).Scan(&lastID)
+	if err != nil {
+		return rollback("unable to read rollup state", err)
+	}
+	if safeID <= lastID && !rebuild {
+		return 0, tx.Commit()
+	}
+	// retention may have pruned older rows, so only the buckets from the first whole one still in the collect table are rebuilt
+	var minTime sql.NullInt64
+	if rebuild {
+		err = tx.QueryRowContext(ctx, "SELECT min(timedate) FROM "+CollectTable+";").Scan(&minTime)
+		if err != nil {
+			return rollback("unable to find the oldest collected row", err)
+		}
+	}
+	var rolledUp int64
+	for i, rollup := range rollups {
+		condition := "unique_id > $1 AND unique_id <= $2"
+		args := []interface{}{lastID, safeID}
+		if minTime.Valid {
+			start := (minTime.Int64 + rollup.bucket - 1) / rollup.bucket * rollup.bucket
+			_, err = tx.ExecContext(ctx, "DELETE FROM "+rollup.table+" WHERE bucket >= $1;", start)
+			if err != nil {
+				return rollback("unable to empty "+rollup.table, err)
+			}
+			condition = "unique_id <= $2 AND (unique_id > $1 OR COALESCE(timedate, 0) >= $3)"
+			args = append(args, start)
+		}
+		// the finest rollup table rolls up the most rows
+		if i == 0 {
+			err = tx.QueryRowContext(ctx, "SELECT count(*) FROM "+CollectTable+" WHERE "+condition+";", args...).Scan(&rolledUp)
+			if err != nil {
+				return rollback("unable to count rolled up rows", err)
+			}
+		}
+		bucket := "$" + strconv.Itoa(len(args)+1)
+		query := `
+INSERT INTO ` + rollup.table + ` (bucket, frontend, path, cfipcountry, status, hits)
+SELECT (COALESCE(timedate, 0) / ` + bucket + `) * ` + bucket + `, COALESCE(frontend, ''), COALESCE(path, ''), COALESCE(cfipcountry, ''), COALESCE(status, 0), count(*)
+FROM ` + CollectTable + `
+WHERE ` + condition + `
+GROUP BY 1, 2, 3, 4, 5
+ON CONFLICT (bucket, frontend, path, cfipcountry, status) DO UPDATE SET hits = ` + rollup.table + `.hits + EXCLUDED.hits;`
+		_, err = tx.ExecContext(ctx, query, append(args, rollup.bucket)...)
+		if err != nil {
+			return rollback("unable to update "+rollup.table, err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE "+RollupStateTable+" SET last_id = $2 WHERE name = $1;", rollupStateName, safeID)
+	if err != nil {
+		return rollback("unable to update rollup state", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		logging.LogIt("rollUp", "ERROR", "unable to commit transaction")
+		return 0, err
+	}
+	return rolledUp, nil
+}
+
+// StartRollups Keep the rollup tables up to date every interval, in the background, until ctx is cancelled
+func StartRollups(ctx context.Context, interval time.Duration) {
+	Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rolledUp, err := UpdateRollups(ctx)
+				if err != nil {
+					logging.LogIt("StartRollups", "ERROR", "rollup failed: "+fmt.Sprintln(err))
+					continue
+				}
+				if rolledUp > 0 {
+					logging.LogIt("StartRollups", "INFO", strconv.FormatInt(rolledUp, 10)+" collected rows rolled up")
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package internaldb
+
+import (
+	"context"
+	"time"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+// FetchUnchecked Fetch up to limit collected rows the rules engine has not checked yet, oldest first
+func FetchUnchecked(ctx context.Context, limit int) ([]CollectionData, error) {
+	defer logging.TrackTime("FetchUnchecked", time.Now())
+	query := "SELECT " + collectedColumns + " FROM " + CollectTable + `
+WHERE checked IS NOT TRUE
+ORDER BY unique_id
+LIMIT $1;`
+	rows, dbCheck := Db.QueryContext(ctx, query, limit)
+	if dbCheck != nil {
+		logging.LogIt("FetchUnchecked", "ERROR", "unable to query db")
+		return nil, dbCheck
+	}
+	defer func() {
+		errClose := rows.Close()
+		if errClose != nil {
+			logging.LogIt("FetchUnchecked", "ERROR", "error closing query")
+		}
+	}()
+	var unchecked []CollectionData
+	for rows.Next() {
+		var collectedData CollectionData
+		errRows := scanCollected(rows, &collectedData)
+		if errRows != nil {
+			logging.LogIt("FetchUnchecked", "ERROR", "unable to scan rows")
+			return nil, errRows
+		}
+		unchecked = append(unchecked, collectedData)
+	}
+	return unchecked, rows.Err()
+}
+
+// MarkChecked Mark the collected rows as checked, flag the ones that got banned and record which IPs were checked, in a single transaction
+func MarkChecked(ctx context.Context, checkedIDs []int64, bannedIDs []int64, checked []CheckedData) error {
+	defer logging.TrackTime("MarkChecked", time.Now())
+	tx, err := Db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.LogIt("MarkChecked", "ERROR", "unable to begin transaction")
+		return err
+	}
+	rollback := func(message string, err error) error {
+		logging.LogIt("MarkChecked", "ERROR", message)
+		if errRollback := tx.Rollback(); errRollback != nil {
+			logging.LogIt("MarkChecked", "ERROR", "unable to rollback transaction")
+		}
+		return err
+	}
+	query := "UPDATE " + CollectTable + " SET checked = true, banned = (unique_id = ANY($2)) WHERE unique_id = ANY($1);"
+	_, err = tx.ExecContext(ctx, query, checkedIDs, bannedIDs)
+	if err != nil {
+		return rollback("unable to mark rows as checked", err)
+	}
+	for _, checkedData := range checked {
+		_, err = tx.ExecContext(ctx, "INSERT INTO "+CheckedTable+" (ip, domainname, timechecked) VALUES ($1, $2, $3);",
+			checkedData.IP,
+			checkedData.DomainName,
+			checkedData.TimeDateChecked,
+		)
+		if err != nil {
+			return rollback("unable to insert into "+CheckedTable, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		logging.LogIt("MarkChecked", "ERROR", "unable to commit transaction")
+		return err
+	}
+	return nil
+}
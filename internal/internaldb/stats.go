@@ -0,0 +1,145 @@
+package internaldb
+
+import (
+	"context"
+	"strconv"
+	"time"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+// userAgentFamily buckets user agents into coarse families, checking bots and tools first as they often mimic browsers
+const userAgentFamily = `CASE
+	WHEN useragent IS NULL OR useragent = '' THEN 'Unknown'
+	WHEN useragent ~* '(bot|crawl|spider|slurp)' THEN 'Bot'
+	WHEN useragent ~* '(curl|wget|python|go-http-client|java/|okhttp|libwww)' THEN 'Tool'
+	WHEN useragent ~* 'edg(e|a|ios)?/' THEN 'Edge'
+	WHEN useragent ~* '(opr/|opera)' THEN 'Opera'
+	WHEN useragent ~* '(firefox|fxios)/' THEN 'Firefox'
+	WHEN useragent ~* '(chrome|crios|chromium)/' THEN 'Chrome'
+	WHEN useragent ~* 'safari/' THEN 'Safari'
+	ELSE 'Other'
+END`
+
+// statsGroups the column, or expression, each stats group aggregates collected data by
+var statsGroups = map[string]string{
+	StatsByPath:      "COALESCE(path, '')",
+	StatsByCountry:   "COALESCE(cfipcountry, '')",
+	StatsByFrontend:  "COALESCE(frontend, '')",
+	StatsByUserAgent: userAgentFamily,
+}
+
+// statsRollupGroups the rollup table column each stats group aggregates by. User agents are not rolled up
+var statsRollupGroups = map[string]string{
+	StatsByPath:     "path",
+	StatsByCountry:  "cfipcountry",
+	StatsByFrontend: "frontend",
+	StatsTimeSeries: "",
+}
+
+// IsStatsGroup Reports whether collected data can be aggregated by the group
+func IsStatsGroup(group string) bool {
+	_, ok := statsGroups[group]
+	return ok || group == StatsTimeSeries
+}
+
+// statsRollup returns the rollup table to read the query's window from: the widest one the window is aligned to, or else the finest,
+// along with the whole buckets within the window. ok is false when the group is not rolled up, time series buckets are not whole
+// rollup buckets, or the window holds no whole bucket
+func statsRollup(statsQuery StatsQuery) (table string, from, to int64, ok bool) {
+	if _, rolledUp := statsRollupGroups[statsQuery.Group]; !rolledUp {
+		return "", 0, 0, false
+	}
+	for i := len(rollups) - 1; i >= 0; i-- {
+		width := rollups[i].bucket
+		if statsQuery.Group == StatsTimeSeries && statsQuery.Bucket%width != 0 {
+			continue
+		}
+		aligned := statsQuery.From%width == 0 && statsQuery.To%width == 0
+		if !aligned && i > 0 {
+			continue
+		}
+		from = (statsQuery.From + width - 1) / width * width
+		to = statsQuery.To / width * width
+		return rollups[i].table, from, to, from < to
+	}
+	return "", 0, 0, false
+}
+
+// statsSQL builds the query aggregating collected data for statsQuery. The whole rollup buckets within the window are read from a
+// rollup table, along with the collected rows in the partial buckets at either edge and those not rolled up yet, in a single
+// statement so both agree on the rollup state. Other queries are read from the collect table alone
+func statsSQL(statsQuery StatsQuery) (string, []interface{}) {
+	args := []interface{}{statsQuery.From, statsQuery.To, statsQuery.Frontend}
+	with := ""
+	from := CollectTable + " WHERE timedate >= $1 AND timedate < $2 AND ($3 = '' OR frontend = $3)"
+	hits := "count(*)"
+	key := statsGroups[statsQuery.Group]
+	if rollup, bucketsFrom, bucketsTo, ok := statsRollup(statsQuery); ok {
+		args = append(args, rollupStateName, bucketsFrom, bucketsTo)
+		with = `WITH hits AS (
+	SELECT bucket AS timedate, frontend, path, cfipcountry, hits FROM ` + rollup + `
+	WHERE bucket >= $5 AND bucket < $6 AND ($3 = '' OR frontend = $3)
+	UNION ALL
+	SELECT timedate, COALESCE(frontend, ''), COALESCE(path, ''), COALESCE(cfipcountry, ''), 1 FROM ` + CollectTable + `
+	WHERE timedate >= $1 AND timedate < $2 AND ($3 = '' OR frontend = $3)
+	AND (timedate < $5 OR timedate >= $6 OR unique_id > COALESCE((SELECT last_id FROM ` + RollupStateTable + ` WHERE name = $4), 0))
+) `
+		from = "hits"
+		hits = "sum(hits)::bigint"
+		key = statsRollupGroups[statsQuery.Group]
+	}
+	var query string
+	if statsQuery.Group == StatsTimeSeries {
+		args = append(args, statsQuery.Bucket)
+		n := strconv.Itoa(len(args))
+		query = with + "SELECT (timedate / $" + n + ") * $" + n + " AS bucket, COALESCE(frontend, ''), " + hits + " FROM " + from +
+			" GROUP BY 1, 2 ORDER BY 1, 2;"
+	} else {
+		args = append(args, statsQuery.Limit)
+		query = with + "SELECT " + key + " AS key, " + hits + " FROM " + from +
+			" GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $" + strconv.Itoa(len(args)) + ";"
+	}
+	return query, args
+}
+
+// FetchStats Aggregate collected data within the query's window. Time series are bucketed per frontend, every other group returns its top keys
+func FetchStats(ctx context.Context, statsQuery StatsQuery) (StatsResult, error) {
+	defer logging.TrackTime("FetchStats", time.Now())
+	statsResult := StatsResult{
+		Group:  statsQuery.Group,
+		From:   statsQuery.From,
+		To:     statsQuery.To,
+		Bucket: statsQuery.Bucket,
+		Rows:   []StatsRow{},
+	}
+	query, args := statsSQL(statsQuery)
+	rows, dbCheck := Db.QueryContext(ctx, query, args...)
+	if dbCheck != nil {
+		logging.LogCtx(ctx, "ERROR", "unable to query db")
+		return statsResult, dbCheck
+	}
+	defer func() {
+		errClose := rows.Close()
+		if errClose != nil {
+			logging.LogCtx(ctx, "ERROR", "error closing query")
+		}
+	}()
+	for rows.Next() {
+		var statsRow StatsRow
+		var errRows error
+		if statsQuery.Group == StatsTimeSeries {
+			errRows = rows.Scan(&statsRow.Bucket, &statsRow.Frontend, &statsRow.Hits)
+			statsRow.Key = strconv.FormatInt(statsRow.Bucket, 10)
+		} else {
+			errRows = rows.Scan(&statsRow.Key, &statsRow.Hits)
+		}
+		if errRows != nil {
+			logging.LogCtx(ctx, "ERROR", "unable to scan rows")
+			return statsResult, errRows
+		}
+		statsResult.Rows = append(statsResult.Rows, statsRow)
+	}
+	return statsResult, rows.Err()
+}
@@ -2,6 +2,7 @@ package internaldb
 
 // CollectionData Struct for collected data from frontends
 type CollectionData struct {
+	ID           int64  `json:"id,omitempty"`
 	FrontendName string `json:"frontendName"`
 	TimeDate     int64  `json:"timeDate"`
 	IP           string `json:"ip"`
@@ -14,14 +15,140 @@ type CollectionData struct {
 	Via          string `json:"via"`
 	Age          string `json:"age"`
 	CFIPCountry  string `json:"CF-IPCountry"`
+	// Status the HTTP status the frontend answered with, if it sends one
+	Status int `json:"status,omitempty"`
 }
 
 // BannedData Struct to send banned data to frontends requesting it
 type BannedData struct {
+	ID              int64  `json:"id,omitempty"`
 	FrontendName    string `json:"frontendName"`
 	TimeDateBanned  int64  `json:"timeDateBanned"`
 	TimeDateChecked int64  `json:"timeDateChecked"`
+	ExpiresAt       int64  `json:"expiresAt,omitempty"`
 	IP              string `json:"ip"`
+	Prefix          string `json:"prefix,omitempty"`
 	DomainName      string `json:"domainName"`
+	Reason          string `json:"reason,omitempty"`
 	Banned          bool   `json:"banned"`
+	// Duration is only read when creating a ban, as a shorthand for ExpiresAt (e.g. "24h")
+	Duration string `json:"duration,omitempty"`
+}
+
+// BannedList Struct for a single page of bans, sent to admins listing the banned table
+type BannedList struct {
+	Page  int          `json:"page"`
+	Limit int          `json:"limit"`
+	Total int          `json:"total"`
+	Bans  []BannedData `json:"bans"`
+}
+
+// CollectedFilter Filters and cursor for fetching collected data. Empty fields do not filter
+type CollectedFilter struct {
+	Frontend string
+	IP       string
+	Path     string
+	Method   string
+	Country  string
+	// From and To limit timeDate to [From, To)
+	From int64
+	To   int64
+	// Cursor only returns rows older than the row with this ID, as given in CollectedPage.NextCursor
+	Cursor int64
+	Limit  int
+}
+
+// CollectedPage Struct for a single page of collected data, sent to whoever queries it
+type CollectedPage struct {
+	Data       []CollectionData `json:"data"`
+	NextCursor int64            `json:"nextCursor,omitempty"`
+}
+
+// StatsQuery What to aggregate collected data by, and over which window. From and To are unix timestamps, limiting timeDate to [From, To)
+type StatsQuery struct {
+	Group    string
+	Frontend string
+	From     int64
+	To       int64
+	// Bucket the width of time series buckets, in seconds
+	Bucket int64
+	// Limit how many of the top keys to return, for every group but time series
+	Limit int
+}
+
+// StatsRow Struct for a single aggregated key, or time series bucket, and its number of hits
+type StatsRow struct {
+	Key      string `json:"key"`
+	Bucket   int64  `json:"bucket,omitempty"`
+	Frontend string `json:"frontend,omitempty"`
+	Hits     int64  `json:"hits"`
+}
+
+// StatsResult Struct to send aggregated collected data to dashboards
+type StatsResult struct {
+	Group  string     `json:"group"`
+	From   int64      `json:"from"`
+	To     int64      `json:"to"`
+	Bucket int64      `json:"bucket,omitempty"`
+	Rows   []StatsRow `json:"rows"`
+}
+
+// BatchRecordResult Struct describing whether a single record of a batch was accepted or rejected
+type BatchRecordResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchSummary Struct to send the outcome of a batch ingestion back to frontends
+type BatchSummary struct {
+	Accepted int                 `json:"accepted"`
+	Rejected int                 `json:"rejected"`
+	Results  []BatchRecordResult `json:"results"`
+}
+
+// CheckedData Struct for an IP the rules engine has checked, on behalf of a frontend
+type CheckedData struct {
+	IP              string `json:"ip"`
+	DomainName      string `json:"domainName"`
+	TimeDateChecked int64  `json:"timeDateChecked"`
+}
+
+// Frontend Struct for a frontend registered to send data. Its API key is never exposed
+type Frontend struct {
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"createdAt"`
+	// LastSeen unix time of the last heartbeat, 0 if never heard from
+	LastSeen int64  `json:"lastSeen"`
+	Version  string `json:"version,omitempty"`
+	// Connection the database connection status the frontend last reported
+	Connection string `json:"connection,omitempty"`
+	// HasKey whether the frontend was given an API key to sign requests with
+	HasKey bool `json:"hasKey"`
+	// Silent whether the frontend has not been heard from for too long
+	Silent bool `json:"silent"`
+}
+
+// DatabaseHealth Struct describing how the database is doing, see CheckHealth
+type DatabaseHealth struct {
+	Available bool `json:"available"`
+	// Latency how long a ping took, in seconds
+	Latency             float64   `json:"latency"`
+	SchemaVersion       int       `json:"schemaVersion"`
+	LatestSchemaVersion int       `json:"latestSchemaVersion"`
+	Pool                PoolStats `json:"pool"`
+	Error               string    `json:"error,omitempty"`
+}
+
+// PoolStats Struct for the connection pool statistics of sql.DBStats, durations in seconds
+type PoolStats struct {
+	MaxOpenConnections int     `json:"maxOpenConnections"`
+	OpenConnections    int     `json:"openConnections"`
+	InUse              int     `json:"inUse"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"waitCount"`
+	WaitDuration       float64 `json:"waitDuration"`
+	MaxIdleClosed      int64   `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64   `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64   `json:"maxLifetimeClosed"`
 }
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// scopeKey the context key log fields are stored under
+type scopeKey struct{}
+
+// scope the fields added to every line logged with a context, and the time latency is measured from
+type scope struct {
+	fields []Field
+	start  time.Time
+}
+
+func scopeOf(ctx context.Context) scope {
+	current, _ := ctx.Value(scopeKey{}).(scope)
+	return current
+}
+
+// WithFields Returns a copy of ctx whose log lines carry the given alternating keys and values, after the fields already in ctx
+func WithFields(ctx context.Context, keyValues ...any) context.Context {
+	current := scopeOf(ctx)
+	fields := make([]Field, 0, len(current.fields)+len(keyValues)/2)
+	fields = append(append(fields, current.fields...), pairs(keyValues)...)
+	return context.WithValue(ctx, scopeKey{}, scope{fields: fields, start: current.start})
+}
+
+// WithStart Returns a copy of ctx whose log lines carry the latency since start, e.g. since a request was received
+func WithStart(ctx context.Context, start time.Time) context.Context {
+	current := scopeOf(ctx)
+	return context.WithValue(ctx, scopeKey{}, scope{fields: current.fields, start: start})
+}
+
+// Fields The fields log lines carry with ctx, including the latency so far
+func Fields(ctx context.Context) []Field {
+	current := scopeOf(ctx)
+	// clipped, so appending never writes into fields shared with other contexts
+	fields := current.fields[:len(current.fields):len(current.fields)]
+	if !current.start.IsZero() {
+		fields = append(fields, Field{Key: "latency", Value: time.Since(current.start)})
+	}
+	return fields
+}
+
+// LogCtx Like LogFields, carrying the fields stored in ctx and named after the calling function, so names cannot go stale
+func LogCtx(ctx context.Context, logOutput string, message string, keyValues ...any) {
+	fields := append(Fields(ctx), pairs(keyValues)...)
+	errWrite := Write(Entry{Time: time.Now(), Level: logOutput, Function: caller(2), Message: message, Fields: fields})
+	if errWrite != nil {
+		log.Println(errWrite)
+	}
+}
+
+// closure matches the suffix the compiler gives function literals, e.g. .func1 or .func2.1
+var closure = regexp.MustCompile(`(\.func\d+)+(\.\d+)*$`)
+
+// caller The name of the function skip frames up the stack, without package path and receiver, e.g. InsertCollectedData for
+// zehd-backend/internal/internaldb.(*CollectionData).InsertCollectedData
+func caller(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
+	if !ok {
+		return "unknown"
+	}
+	name := runtime.FuncForPC(pc).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = closure.ReplaceAllString(name, "")
+	return name[strings.LastIndex(name, ".")+1:]
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// format Formats an entry as a single line in the given format, text unless json or logfmt. Text lines only carry the time
+// when withTime is set, json and logfmt lines always do
+func format(name string, entry Entry, withTime bool) []byte {
+	switch name {
+	case "json":
+		return formatJSON(entry)
+	case "logfmt":
+		return formatLogfmt(entry)
+	default:
+		return formatText(entry, withTime)
+	}
+}
+
+// formatText The classic "function [ LEVEL ] ==> message" line, followed by the fields as key=value
+func formatText(entry Entry, withTime bool) []byte {
+	var line bytes.Buffer
+	if withTime {
+		line.WriteString(entry.Time.Format("2006-01-02 15:04:05 "))
+	}
+	line.WriteString(entry.Function + " [ " + entry.Level + " ] ==> " + entry.Message)
+	for _, field := range entry.Fields {
+		line.WriteString(" " + field.Key + "=" + logfmtValue(field.Value))
+	}
+	line.WriteByte('\n')
+	return line.Bytes()
+}
+
+// formatJSON A JSON object with time, level, func and msg, followed by the fields
+func formatJSON(entry Entry) []byte {
+	var line bytes.Buffer
+	line.WriteString(`{"time":`)
+	writeJSON(&line, entry.Time.Format(time.RFC3339Nano))
+	line.WriteString(`,"level":`)
+	writeJSON(&line, entry.Level)
+	line.WriteString(`,"func":`)
+	writeJSON(&line, entry.Function)
+	line.WriteString(`,"msg":`)
+	writeJSON(&line, entry.Message)
+	for _, field := range entry.Fields {
+		line.WriteByte(',')
+		writeJSON(&line, field.Key)
+		line.WriteByte(':')
+		writeJSON(&line, value(field.Value))
+	}
+	line.WriteString("}\n")
+	return line.Bytes()
+}
+
+// writeJSON Writes v as JSON, or as a JSON string of its printed form when it cannot be marshalled
+func writeJSON(line *bytes.Buffer, v any) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(v))
+	}
+	line.Write(encoded)
+}
+
+// formatLogfmt time, level, func, msg and the fields as key=value pairs, quoting values where needed
+func formatLogfmt(entry Entry) []byte {
+	var line bytes.Buffer
+	line.WriteString("time=" + entry.Time.Format(time.RFC3339Nano))
+	line.WriteString(" level=" + logfmtValue(entry.Level))
+	line.WriteString(" func=" + logfmtValue(entry.Function))
+	line.WriteString(" msg=" + logfmtValue(entry.Message))
+	for _, field := range entry.Fields {
+		line.WriteString(" " + field.Key + "=" + logfmtValue(field.Value))
+	}
+	line.WriteByte('\n')
+	return line.Bytes()
+}
+
+// logfmtValue The printed value, quoted when empty or holding spaces, quotes, equal signs or control characters
+func logfmtValue(v any) string {
+	printed := stringValue(v)
+	if printed == "" || strings.IndexFunc(printed, func(r rune) bool {
+		return r == ' ' || r == '"' || r == '=' || unicode.IsControl(r)
+	}) >= 0 {
+		return strconv.Quote(printed)
+	}
+	return printed
+}
+
+// value Errors and values with a String method are written as text, e.g. durations as 1.5s instead of nanoseconds
+func value(v any) any {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+// stringValue The value as text, empty for nil
+func stringValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(value(v))
+}
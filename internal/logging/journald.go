@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+	. "zehd-backend/internal"
+)
+
+// JournaldSink Sends entries to journald over its native protocol, keeping the level, function and fields as journal fields, e.g.
+// PRIORITY=4, CODE_FUNC=InsertCollectedData and REQUEST_ID=4b1f0c2e, which journalctl can filter on
+type JournaldSink struct {
+	conn *net.UnixConn
+	tag  string
+}
+
+// NewJournaldSink Connects to journald's socket, JournalSocket unless journald runs elsewhere. Entries are sent with tag as
+// SYSLOG_IDENTIFIER, the same as syslog's APP-NAME, DefaultSyslogTag when empty
+func NewJournaldSink(socket string, tag string) (*JournaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		tag = DefaultSyslogTag
+	}
+	return &JournaldSink{conn: conn, tag: tag}, nil
+}
+
+// Write Sends an entry as a single datagram. Entries too large for a datagram are refused by the kernel, and reported
+func (sink *JournaldSink) Write(entry Entry) error {
+	var datagram bytes.Buffer
+	writeJournalField(&datagram, "MESSAGE", entry.Message)
+	writeJournalField(&datagram, "PRIORITY", strconv.Itoa(severity(entry.Level)))
+	writeJournalField(&datagram, "CODE_FUNC", entry.Function)
+	writeJournalField(&datagram, "SYSLOG_IDENTIFIER", sink.tag)
+	for _, field := range entry.Fields {
+		name := journalName(field.Key)
+		if name != "" {
+			writeJournalField(&datagram, name, stringValue(field.Value))
+		}
+	}
+	_, err := sink.conn.Write(datagram.Bytes())
+	return err
+}
+
+func (sink *JournaldSink) Close() error {
+	return sink.conn.Close()
+}
+
+// writeJournalField Writes NAME=value, or for values spanning lines NAME, the value's length as a little endian 64-bit integer and
+// the value, as the native protocol requires
+func writeJournalField(datagram *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		datagram.WriteString(name + "=" + value + "\n")
+		return
+	}
+	datagram.WriteString(name + "\n")
+	_ = binary.Write(datagram, binary.LittleEndian, uint64(len(value)))
+	datagram.WriteString(value + "\n")
+}
+
+// journalName Turns a field key into a journal field name, which only holds upper case letters, digits and underscores and does
+// not start with an underscore or digit, e.g. requestID into REQUEST_ID
+func journalName(key string) string {
+	var name strings.Builder
+	var previous rune
+	for _, r := range key {
+		switch {
+		case unicode.IsUpper(r) && (unicode.IsLower(previous) || unicode.IsDigit(previous)):
+			name.WriteRune('_')
+			name.WriteRune(r)
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			name.WriteRune(unicode.ToUpper(r))
+		default:
+			name.WriteRune('_')
+		}
+		previous = r
+	}
+	return strings.TrimLeft(name.String(), "_0123456789")
+}
@@ -1,15 +1,93 @@
 package logging
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
-	"zehd-backend/internal/env"
-	"strconv"
+	. "zehd-backend/internal"
+	"zehd-backend/internal/metrics"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Field a key/value pair added to a log line
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Entry a log line before it is formatted
+type Entry struct {
+	Time     time.Time
+	Level    string
+	Function string
+	Message  string
+	Fields   []Field
+}
+
+// levels known log levels, from least to most severe. Unknown levels are always written
+var levels = map[string]int{"DEBUG": 1, "INFO": 2, "WARNING": 3, "ERROR": 4}
+
+// mu guards the settings and sinks below, so lines never interleave
+var mu sync.Mutex
+
+// settings how and where lines are written. Until Configure is called, text lines go to $HOME/log/backend.log and stdout
+var settings LogConfig
+
+// minLevel the least severe level written, everything is written when unset
+var minLevel int
+
+// sinks where lines are written, opened from settings on the first line written when Configure was not called or after Close
+var sinks []Sink
+
+// Configure Set where logs are written, their format, rotation, and the least severe level written. The sinks are opened straight
+// away, so an unwritable file or unreachable syslog daemon is reported on startup. Empty values keep the defaults
+func Configure(conf LogConfig) error {
+	mu.Lock()
+	defer mu.Unlock()
+	errClose := closeSinks()
+	settings = conf
+	minLevel = levels[strings.ToUpper(conf.Level)]
+	var err error
+	sinks, err = openSinks(conf)
+	if err != nil {
+		return err
+	}
+	return errClose
+}
+
+// AddSink Write lines to sink as well, until Close or Configure is called
+func AddSink(sink Sink) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if sinks == nil {
+		var err error
+		sinks, err = openSinks(settings)
+		if err != nil {
+			return err
+		}
+	}
+	sinks = append(sinks, sink)
+	return nil
+}
+
+// Close Close the sinks, after rotated files are compressed. Lines logged afterwards reopen them
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	return closeSinks()
+}
+
+func closeSinks() error {
+	var errs []error
+	for _, sink := range sinks {
+		errs = append(errs, sink.Close())
+	}
+	sinks = nil
+	return errors.Join(errs...)
+}
+
 // LogIt Boilerplate funtion that calls Logger, to write/prints logs
 func LogIt(logFunction string, logOutput string, message string) {
 	errCloseLogger := Logger(logFunction, logOutput, message)
@@ -18,41 +96,64 @@ func LogIt(logFunction string, logOutput string, message string) {
 	}
 }
 
+// LogFields Like LogIt, with alternating keys and values added to the line, e.g. LogFields("Fn", "INFO", "inserted", "rows", 5)
+func LogFields(logFunction string, logOutput string, message string, keyValues ...any) {
+	errWrite := Write(Entry{Time: time.Now(), Level: logOutput, Function: logFunction, Message: message, Fields: pairs(keyValues)})
+	if errWrite != nil {
+		log.Println(errWrite)
+	}
+}
+
 // Logger This function is called by Logit and prints/writes logs
 func Logger(logFunction, logOutput, message string) error {
-	currentDate := time.Now().Format("2006-01-02 15:04:05")
-	pathString := os.Getenv("HOME") + "/log/"
-	path, _ := filepath.Abs(pathString)
-	err := os.MkdirAll(path, os.ModePerm)
-	if err == nil || os.IsExist(err) {
-		logFile, err := os.OpenFile(pathString+"backend.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return Write(Entry{Time: time.Now(), Level: logOutput, Function: logFunction, Message: message})
+}
+
+// Write Writes an entry to the sinks, unless its level is below the minimum level
+func Write(entry Entry) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if level, ok := levels[entry.Level]; ok && level < minLevel {
+		return nil
+	}
+	entry.Message = strings.TrimRight(entry.Message, "\n")
+	if sinks == nil {
+		var err error
+		sinks, err = openSinks(settings)
 		if err != nil {
 			return err
 		}
-		defer func() {
-			errClose := logFile.Close()
-			if errClose != nil {
-				log.Println(errClose)
-			}
-		}()
-		logger := log.New(logFile, "", log.LstdFlags)
-		logger.SetPrefix(currentDate)
-		logger.Print(logFunction + " [ " + logOutput + " ] ==> " + message)
-	} else {
-		return err
 	}
-	fmt.Println(logFunction + " [ " + logOutput + " ] ==> " + message)
-	return nil
+	var errs []error
+	for _, sink := range sinks {
+		errs = append(errs, sink.Write(entry))
+	}
+	return errors.Join(errs...)
+}
+
+// pairs turns alternating keys and values into fields. A key without a value is kept with a nil value
+func pairs(keyValues []any) []Field {
+	fields := make([]Field, 0, (len(keyValues)+1)/2)
+	for i := 0; i < len(keyValues); i += 2 {
+		field := Field{Key: fmt.Sprint(keyValues[i])}
+		if i+1 < len(keyValues) {
+			field.Value = keyValues[i+1]
+		}
+		fields = append(fields, field)
+	}
+	return fields
 }
 
+// taskDuration durations measured by TrackTime, exposed as metrics
+var taskDuration = metrics.NewHistogramVec("zehd_db_duration_seconds", "Time taken by database functions, by the name passed to TrackTime",
+	metrics.DefaultBuckets, "function")
+
 // TrackTime defer this function right at the beginning, to track time from start to finish
 func TrackTime(taskName string, pre time.Time) time.Duration {
 	elapsed := time.Since(pre)
-	profiler, err := strconv.ParseBool(env.EnvProfiler())
-	if err != nil {
-		fmt.Println(err)
-	}
-	if profiler {
+	taskDuration.Observe(elapsed.Seconds(), taskName)
+	if profiling.Load() {
+		recordMeasurement(taskName, elapsed)
 		fmt.Printf("%v ", taskName)
 		fmt.Println("elapsed:", elapsed)
 	}
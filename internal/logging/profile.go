@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	. "zehd-backend/internal"
+	"zehd-backend/internal/env"
+)
+
+// profiling whether TrackTime prints and keeps measurements, PROFILER until ConfigureProfiler is called
+var profiling atomic.Bool
+
+// measurements the latest TrackTime measurements of every task, oldest first, at most samples of them
+var measurements = struct {
+	sync.Mutex
+	samples int
+	tasks   map[string][]time.Duration
+}{samples: DefaultProfilerSamples, tasks: map[string][]time.Duration{}}
+
+func init() {
+	profiler, err := strconv.ParseBool(env.EnvProfiler())
+	if err != nil {
+		fmt.Println(err)
+	}
+	profiling.Store(profiler)
+}
+
+// ConfigureProfiler Set whether TrackTime prints and keeps measurements, and how many of the latest are kept per task
+func ConfigureProfiler(enabled bool, samples int) {
+	measurements.Lock()
+	defer measurements.Unlock()
+	measurements.samples = samples
+	for task, durations := range measurements.tasks {
+		if len(durations) > samples {
+			measurements.tasks[task] = durations[len(durations)-samples:]
+		}
+	}
+	profiling.Store(enabled)
+}
+
+func recordMeasurement(task string, elapsed time.Duration) {
+	measurements.Lock()
+	defer measurements.Unlock()
+	durations := append(measurements.tasks[task], elapsed)
+	if len(durations) > measurements.samples {
+		// dropping the oldest uses up the capacity, so append moves the kept ones to a new array every so often
+		durations = durations[len(durations)-measurements.samples:]
+	}
+	measurements.tasks[task] = durations
+}
+
+// TaskProfile The latest TrackTime measurements of a task, in milliseconds
+type TaskProfile struct {
+	Task  string  `json:"task"`
+	Count int     `json:"count"`
+	P50   float64 `json:"p50Ms"`
+	P95   float64 `json:"p95Ms"`
+	P99   float64 `json:"p99Ms"`
+	Max   float64 `json:"maxMs"`
+	// Recent the latest measurements, oldest first
+	Recent []float64 `json:"recentMs"`
+}
+
+// Profile The latest measurements of every task, or of the given tasks, by task name. At most recent measurements are listed per
+// task, all that are kept if recent is 0; the percentiles cover all of them either way
+func Profile(recent int, tasks ...string) []TaskProfile {
+	measurements.Lock()
+	defer measurements.Unlock()
+	profiles := []TaskProfile{}
+	for task, durations := range measurements.tasks {
+		if len(tasks) > 0 && !slices.Contains(tasks, task) {
+			continue
+		}
+		sorted := slices.Clone(durations)
+		slices.Sort(sorted)
+		listed := durations
+		if recent > 0 && len(listed) > recent {
+			listed = listed[len(listed)-recent:]
+		}
+		profile := TaskProfile{
+			Task:   task,
+			Count:  len(durations),
+			P50:    milliseconds(percentile(sorted, 50)),
+			P95:    milliseconds(percentile(sorted, 95)),
+			P99:    milliseconds(percentile(sorted, 99)),
+			Max:    milliseconds(sorted[len(sorted)-1]),
+			Recent: make([]float64, len(listed)),
+		}
+		for i, duration := range listed {
+			profile.Recent[i] = milliseconds(duration)
+		}
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Task < profiles[j].Task })
+	return profiles
+}
+
+// percentile The nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
@@ -0,0 +1,174 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	. "zehd-backend/internal"
+)
+
+// RotatingFile A log file kept open between lines. Once it grows past maxSize or has been written to for maxAge, it is renamed
+// with the time it was rotated appended, optionally gzipped, and a new file is started. It is safe for concurrent use
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// cleanup compresses and prunes rotated files in the background, one rotation at a time
+	cleanup  sync.Mutex
+	cleanups sync.WaitGroup
+}
+
+// OpenRotating Opens conf.File, $HOME/log/backend.log if empty, to be rotated as conf says
+func OpenRotating(conf LogConfig) (*RotatingFile, error) {
+	path := conf.File
+	if path == "" {
+		path = os.Getenv("HOME") + "/log/backend.log"
+	}
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    int64(conf.MaxSize) * 1024 * 1024,
+		maxAge:     conf.MaxAge,
+		maxBackups: conf.MaxBackups,
+		compress:   conf.Compress,
+	}
+	return f, f.open()
+}
+
+func (f *RotatingFile) open() error {
+	err := os.MkdirAll(filepath.Dir(f.path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write Writes a line, rotating the file first when the line would take it past maxSize or it is older than maxAge
+func (f *RotatingFile) Write(line []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		err := f.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	tooBig := f.maxSize > 0 && f.size+int64(len(line)) > f.maxSize
+	tooOld := f.maxAge > 0 && time.Since(f.opened) >= f.maxAge
+	if f.size > 0 && (tooBig || tooOld) {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+	rotated := f.path + "." + time.Now().Format("2006-01-02T15-04-05.000000")
+	err = os.Rename(f.path, rotated)
+	if err != nil {
+		return err
+	}
+	f.cleanups.Add(1)
+	go func() {
+		defer f.cleanups.Done()
+		f.cleanup.Lock()
+		defer f.cleanup.Unlock()
+		if f.compress {
+			errCompress := compressFile(rotated)
+			if errCompress != nil {
+				log.Println(errCompress)
+			}
+		}
+		f.prune()
+	}()
+	return f.open()
+}
+
+// compressFile Replaces a file with a gzipped copy
+func compressFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(target)
+	_, err = io.Copy(gz, source)
+	if err == nil {
+		err = gz.Close()
+	}
+	if errClose := target.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune Removes the oldest rotated files beyond maxBackups. Rotated names end with the time they were rotated, so they sort
+// from oldest to newest
+func (f *RotatingFile) prune() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	rotated, err := filepath.Glob(f.path + ".*")
+	if err != nil || len(rotated) <= f.maxBackups {
+		return
+	}
+	sort.Strings(rotated)
+	for _, path := range rotated[:len(rotated)-f.maxBackups] {
+		errRemove := os.Remove(path)
+		if errRemove != nil {
+			log.Println(errRemove)
+		}
+	}
+}
+
+// Close Waits for rotated files to be compressed, and closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cleanups.Wait()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
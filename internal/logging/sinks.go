@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"errors"
+	"os"
+	. "zehd-backend/internal"
+)
+
+// Sink A destination log entries are written to, one at a time
+type Sink interface {
+	Write(entry Entry) error
+	Close() error
+}
+
+// openSinks Opens the sinks conf.Output lists, closing those already opened when one fails
+func openSinks(conf LogConfig) ([]Sink, error) {
+	var opened []Sink
+	for _, output := range conf.Outputs() {
+		sink, err := openSink(output, conf)
+		if err != nil {
+			for _, sink := range opened {
+				sink.Close()
+			}
+			return nil, err
+		}
+		opened = append(opened, sink)
+	}
+	return opened, nil
+}
+
+func openSink(output string, conf LogConfig) (Sink, error) {
+	switch output {
+	case "file":
+		file, err := OpenRotating(conf)
+		if err != nil {
+			return nil, err
+		}
+		return &fileSink{file: file, format: conf.Format}, nil
+	case "stdout":
+		return stdoutSink{format: conf.Format}, nil
+	case "journald":
+		return NewJournaldSink(JournalSocket, conf.Syslog.Tag)
+	case "syslog":
+		return NewSyslogSink(conf.Syslog)
+	default:
+		return nil, errors.New("unknown log output " + output)
+	}
+}
+
+// fileSink writes formatted lines, with their time, to a rotated file
+type fileSink struct {
+	file   *RotatingFile
+	format string
+}
+
+func (sink *fileSink) Write(entry Entry) error {
+	_, err := sink.file.Write(format(sink.format, entry, true))
+	return err
+}
+
+func (sink *fileSink) Close() error {
+	return sink.file.Close()
+}
+
+// stdoutSink writes formatted lines to stdout, which is usually collected by journald or docker, adding their own timestamps
+type stdoutSink struct {
+	format string
+}
+
+func (sink stdoutSink) Write(entry Entry) error {
+	_, err := os.Stdout.Write(format(sink.format, entry, false))
+	return err
+}
+
+func (sink stdoutSink) Close() error {
+	return nil
+}
+
+// severities syslog severities of the known levels, which journald's PRIORITY uses as well. Other levels are informational
+var severities = map[string]int{"DEBUG": 7, "INFO": 6, "WARNING": 4, "ERROR": 3}
+
+func severity(level string) int {
+	if severity, ok := severities[level]; ok {
+		return severity
+	}
+	return 6
+}
@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	. "zehd-backend/internal"
+)
+
+// SyslogSink Sends entries as RFC 5424 messages to a syslog daemon over a unix datagram socket or UDP, with the function as MSGID
+// and the fields as structured data fields@<enterpriseID>, or appended to the message as key=value without an enterprise ID
+type SyslogSink struct {
+	network      string
+	address      string
+	conn         net.Conn
+	facility     int
+	tag          string
+	enterpriseID string
+	hostname     string
+}
+
+// NewSyslogSink Connects to the syslog daemon at conf.Address, unix:///dev/log or udp://host:514
+func NewSyslogSink(conf SyslogConfig) (*SyslogSink, error) {
+	address, err := url.Parse(conf.Address)
+	if err != nil {
+		return nil, err
+	}
+	sink := &SyslogSink{tag: conf.Tag, enterpriseID: conf.EnterpriseID, facility: slices.Index(SyslogFacilities, conf.Facility)}
+	switch address.Scheme {
+	case "unix":
+		sink.network, sink.address = "unixgram", address.Path
+	case "udp":
+		sink.network, sink.address = "udp", address.Host
+	default:
+		return nil, errors.New("syslog address must be unix:///path or udp://host:port, got " + conf.Address)
+	}
+	if sink.facility < 0 {
+		return nil, errors.New("unknown syslog facility " + conf.Facility)
+	}
+	if sink.tag == "" {
+		sink.tag = DefaultSyslogTag
+	}
+	sink.hostname, err = os.Hostname()
+	if err != nil {
+		sink.hostname = "-"
+	}
+	sink.conn, err = net.Dial(sink.network, sink.address)
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Write Sends an entry as a single message, reconnecting once should the daemon have been restarted
+func (sink *SyslogSink) Write(entry Entry) error {
+	message := sink.message(entry)
+	_, err := sink.conn.Write(message)
+	if err == nil {
+		return nil
+	}
+	conn, errDial := net.Dial(sink.network, sink.address)
+	if errDial != nil {
+		return err
+	}
+	sink.conn.Close()
+	sink.conn = conn
+	_, err = sink.conn.Write(message)
+	return err
+}
+
+func (sink *SyslogSink) Close() error {
+	return sink.conn.Close()
+}
+
+// message <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (sink *SyslogSink) message(entry Entry) []byte {
+	header := "<" + strconv.Itoa(sink.facility*8+severity(entry.Level)) + ">1 " +
+		entry.Time.Format("2006-01-02T15:04:05.000000Z07:00") + " " +
+		syslogName(sink.hostname, 255) + " " + syslogName(sink.tag, 48) + " " + strconv.Itoa(os.Getpid()) + " " +
+		syslogName(entry.Function, 32) + " "
+	if sink.enterpriseID == "" {
+		message := entry.Message
+		for _, field := range entry.Fields {
+			message += " " + field.Key + "=" + logfmtValue(field.Value)
+		}
+		return []byte(header + "- " + message)
+	}
+	return []byte(header + structuredData(sink.enterpriseID, entry.Fields) + " " + entry.Message)
+}
+
+// paramEscaper escapes the characters RFC 5424 requires escaping in parameter values
+var paramEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// structuredData The fields as a single SD-ELEMENT named fields@enterpriseID, or - without fields
+func structuredData(enterpriseID string, fields []Field) string {
+	if len(fields) == 0 {
+		return "-"
+	}
+	var element strings.Builder
+	element.WriteString("[fields@" + enterpriseID)
+	for _, field := range fields {
+		element.WriteString(" " + syslogName(field.Key, 32) + `="` + paramEscaper.Replace(stringValue(field.Value)) + `"`)
+	}
+	element.WriteString("]")
+	return element.String()
+}
+
+// syslogName Header fields and parameter names are printable ASCII without spaces, =, ] or ", and NILVALUE (-) when empty
+func syslogName(name string, maxLength int) string {
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > maxLength {
+		name = name[:maxLength]
+	}
+	if name == "" {
+		return "-"
+	}
+	return name
+}
@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets histogram buckets, in seconds, suited to request and query durations
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// family a metric with all of its label combinations, written in the Prometheus text exposition format
+type family interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []family
+)
+
+// register adds a metric to those Handler exposes
+func register(metric family) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, metric)
+}
+
+// Handler Endpoint exposing every registered metric in the Prometheus text exposition format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "405 Status Method Not Allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	Write(w)
+}
+
+// Write Write every registered metric, by name, in the Prometheus text exposition format
+func Write(w io.Writer) {
+	registryMu.Lock()
+	families := append([]family(nil), registry...)
+	registryMu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name() < families[j].name() })
+	for _, metric := range families {
+		metric.write(w)
+	}
+}
+
+// vec label names of a metric, and the values of each series, keyed by their rendered labels
+type vec[V any] struct {
+	metricName string
+	help       string
+	labels     []string
+	mu         sync.Mutex
+	series     map[string]*V
+}
+
+func (v *vec[V]) name() string {
+	return v.metricName
+}
+
+// get returns the series for the label values, creating it with create if it does not exist yet
+func (v *vec[V]) get(labelValues []string, create func() *V) *V {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", v.metricName, len(v.labels), len(labelValues)))
+	}
+	key := renderLabels(v.labels, labelValues)
+	series, ok := v.series[key]
+	if !ok {
+		series = create()
+		v.series[key] = series
+	}
+	return series
+}
+
+// sortedKeys returns the rendered labels of every series, sorted so the output is stable
+func (v *vec[V]) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec Counter partitioned by labels
+type CounterVec struct {
+	vec[float64]
+}
+
+// NewCounterVec Create and register a counter
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	counter := &CounterVec{vec[float64]{metricName: name, help: help, labels: labels, series: make(map[string]*float64)}}
+	register(counter)
+	return counter
+}
+
+// Add Add value, which must not be negative, to the series with the label values
+func (counter *CounterVec) Add(value float64, labelValues ...string) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	*counter.get(labelValues, func() *float64 { return new(float64) }) += value
+}
+
+// Inc Add one to the series with the label values
+func (counter *CounterVec) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+func (counter *CounterVec) write(w io.Writer) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	writeHeader(w, counter.metricName, counter.help, "counter")
+	for _, key := range counter.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", counter.metricName, key, formatValue(*counter.series[key]))
+	}
+}
+
+// histogram a single series of a HistogramVec
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec Histogram partitioned by labels
+type HistogramVec struct {
+	vec[histogram]
+	buckets []float64
+}
+
+// NewHistogramVec Create and register a histogram with the upper bounds of its buckets, in increasing order
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	histogramVec := &HistogramVec{vec[histogram]{metricName: name, help: help, labels: labels, series: make(map[string]*histogram)}, buckets}
+	register(histogramVec)
+	return histogramVec
+}
+
+// Observe Add an observation to the series with the label values
+func (histogramVec *HistogramVec) Observe(value float64, labelValues ...string) {
+	histogramVec.mu.Lock()
+	defer histogramVec.mu.Unlock()
+	series := histogramVec.get(labelValues, func() *histogram { return &histogram{counts: make([]uint64, len(histogramVec.buckets))} })
+	for i, bound := range histogramVec.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+// ObserveDuration Add the time passed since start, in seconds, to the series with the label values
+func (histogramVec *HistogramVec) ObserveDuration(start time.Time, labelValues ...string) {
+	histogramVec.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (histogramVec *HistogramVec) write(w io.Writer) {
+	histogramVec.mu.Lock()
+	defer histogramVec.mu.Unlock()
+	writeHeader(w, histogramVec.metricName, histogramVec.help, "histogram")
+	for _, key := range histogramVec.sortedKeys() {
+		series := histogramVec.series[key]
+		for i, bound := range histogramVec.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", histogramVec.metricName, withLabel(key, "le", formatValue(bound)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", histogramVec.metricName, withLabel(key, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", histogramVec.metricName, key, formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", histogramVec.metricName, key, series.count)
+	}
+}
+
+// gaugeFunc a gauge or counter read when metrics are written
+type gaugeFunc struct {
+	metricName string
+	help       string
+	kind       string
+	value      func() float64
+}
+
+// NewGaugeFunc Register a gauge whose value is read from value whenever metrics are written
+func NewGaugeFunc(name, help string, value func() float64) {
+	register(&gaugeFunc{metricName: name, help: help, kind: "gauge", value: value})
+}
+
+// NewCounterFunc Register a counter kept elsewhere, read from value whenever metrics are written
+func NewCounterFunc(name, help string, value func() float64) {
+	register(&gaugeFunc{metricName: name, help: help, kind: "counter", value: value})
+}
+
+func (gauge *gaugeFunc) name() string {
+	return gauge.metricName
+}
+
+func (gauge *gaugeFunc) write(w io.Writer) {
+	writeHeader(w, gauge.metricName, gauge.help, gauge.kind)
+	fmt.Fprintf(w, "%s %s\n", gauge.metricName, formatValue(gauge.value()))
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, kind)
+}
+
+// renderLabels renders label pairs as {name="value",...}, escaping values, or nothing without labels
+func renderLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escape.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label to rendered labels
+func withLabel(rendered, name, value string) string {
+	if rendered == "" {
+		return "{" + name + `="` + value + `"}`
+	}
+	return strings.TrimSuffix(rendered, "}") + "," + name + `="` + value + `"}`
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"zehd-backend/internal/logging"
+)
+
+// accessRecord what handlers further down learn about a request, which the access log cannot see in their contexts
+type accessRecord struct {
+	frontend string
+}
+
+// AccessLog Writes a line for every request answered, in the Common or Combined Log Format or as JSON
+type AccessLog struct {
+	mu            sync.Mutex
+	out           io.Writer
+	format        string
+	collectSample float64
+}
+
+// NewAccessLog An access log writing to out in format: common, combined or json. Only a collectSample share, from 0 to 1, of the
+// successful requests to /api/collect and /api/collect/batch is written, as frontends send those far more often than anything else
+func NewAccessLog(out io.Writer, format string, collectSample float64) *AccessLog {
+	return &AccessLog{out: out, format: format, collectSample: collectSample}
+}
+
+// accessRecorder remembers the status a handler answered with, and how many bytes of body it wrote
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (recorder *accessRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *accessRecorder) Write(body []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	n, err := recorder.ResponseWriter.Write(body)
+	recorder.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (recorder *accessRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+// Handler Write a line for every request next answers. A nil AccessLog writes nothing
+func (accessLog *AccessLog) Handler(next http.HandlerFunc) http.HandlerFunc {
+	if accessLog == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		record := &accessRecord{}
+		recorder := &accessRecorder{ResponseWriter: w}
+		next(recorder, r.WithContext(context.WithValue(r.Context(), accessKey, record)))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if recorder.status < 400 && (r.URL.Path == "/api/collect" || r.URL.Path == "/api/collect/batch") &&
+			rand.Float64() >= accessLog.collectSample {
+			return
+		}
+		line := accessLog.line(r, record, recorder, start)
+		accessLog.mu.Lock()
+		defer accessLog.mu.Unlock()
+		_, err := accessLog.out.Write(line)
+		if err != nil {
+			logging.LogCtx(r.Context(), "ERROR", "unable to write access log: "+err.Error())
+		}
+	}
+}
+
+// accessEntry a request as written to JSON access logs
+type accessEntry struct {
+	Time      string  `json:"time"`
+	RequestID string  `json:"requestID,omitempty"`
+	Remote    string  `json:"remote"`
+	Frontend  string  `json:"frontend,omitempty"`
+	Method    string  `json:"method"`
+	URI       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	LatencyMs float64 `json:"latencyMs"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"userAgent,omitempty"`
+}
+
+func (accessLog *AccessLog) line(r *http.Request, record *accessRecord, recorder *accessRecorder, start time.Time) []byte {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if accessLog.format == "json" {
+		line, _ := json.Marshal(accessEntry{
+			Time:      start.Format(time.RFC3339Nano),
+			RequestID: RequestID(r),
+			Remote:    remote,
+			Frontend:  record.frontend,
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Proto:     r.Proto,
+			Status:    recorder.status,
+			Bytes:     recorder.bytes,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		})
+		return append(line, '\n')
+	}
+	// host ident authuser [date] "request" status bytes, with the frontend as the user
+	line := remote + " - " + orDash(record.frontend) + " [" + start.Format("02/Jan/2006:15:04:05 -0700") + "] " +
+		strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto) + " " + strconv.Itoa(recorder.status) + " "
+	if recorder.bytes == 0 {
+		line += "-"
+	} else {
+		line += strconv.FormatInt(recorder.bytes, 10)
+	}
+	if accessLog.format == "combined" {
+		line += " " + strconv.Quote(orDash(r.Referer())) + " " + strconv.Quote(orDash(r.UserAgent()))
+	}
+	return []byte(line + "\n")
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"zehd-backend/internal/helper"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+// contextKey keys of values middleware adds to request contexts
+type contextKey int
+
+const (
+	frontendKey contextKey = iota
+	requestIDKey
+	// accessKey the access log's record of the request
+	accessKey
+)
+
+// WithFrontend Return a copy of ctx carrying the authenticated frontend name, which is added to the lines logged with it and to
+// the access log
+func WithFrontend(ctx context.Context, frontend string) context.Context {
+	if current, _ := ctx.Value(frontendKey).(string); current != frontend {
+		ctx = logging.WithFields(ctx, "frontend", frontend)
+	}
+	if record, ok := ctx.Value(accessKey).(*accessRecord); ok {
+		record.frontend = frontend
+	}
+	return context.WithValue(ctx, frontendKey, frontend)
+}
+
+// Frontend The authenticated name of the frontend making the request, empty if the request was not authenticated
+func Frontend(r *http.Request) string {
+	frontend, _ := r.Context().Value(frontendKey).(string)
+	return frontend
+}
+
+// RequireClientCert Only let requests presenting a verified client certificate through, recording its common name as the frontend name
+func RequireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			helper.ErrorResponse(w, "Unauthorized: client certificate required", http.StatusUnauthorized)
+			logging.LogCtx(r.Context(), "WARNING", "request to "+r.URL.Path+" without a verified client certificate from "+r.RemoteAddr)
+			return
+		}
+		commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if commonName == "" {
+			helper.ErrorResponse(w, "Forbidden: client certificate has no common name", http.StatusForbidden)
+			logging.LogCtx(r.Context(), "WARNING", "client certificate without a common name from "+r.RemoteAddr)
+			return
+		}
+		next(w, r.WithContext(WithFrontend(r.Context(), commonName)))
+	}
+}
+
+// Sign Compute the signature of a request: the hex encoded HMAC-SHA256, keyed with the frontend's API key, of the timestamp, method,
+// request URI (path and query) and body, separated by newlines
+func Sign(key, timestamp, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + requestURI + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// replayCache remembers signatures seen within the accepted time window, so a captured request cannot be sent again. Signatures are
+// kept in buckets of maxAge seconds, by when they were seen, and whole buckets are forgotten once they are older than the window
+type replayCache struct {
+	mu      sync.Mutex
+	buckets map[int64]map[string]struct{}
+}
+
+// firstSeen records the signature, reporting whether it was not seen before. Signatures are kept for at least 2*maxAge, as long as
+// a timestamp within maxAge either side of now can be replayed
+func (cache *replayCache) firstSeen(signature string, now, maxAge int64) bool {
+	width := max(maxAge, 1)
+	current := now / width
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for bucket := range cache.buckets {
+		if bucket < current-2 {
+			delete(cache.buckets, bucket)
+		}
+	}
+	for _, seen := range cache.buckets {
+		if _, ok := seen[signature]; ok {
+			return false
+		}
+	}
+	if cache.buckets[current] == nil {
+		cache.buckets[current] = make(map[string]struct{})
+	}
+	cache.buckets[current][signature] = struct{}{}
+	return true
+}
+
+// RequireSignature Only let requests signed by a registered frontend through, recording it as the frontend name. Requests carry the
+// frontend name, a unix timestamp within maxAge of now and the signature from Sign in the FrontendHeader, TimestampHeader and
+// SignatureHeader headers. Each signature is accepted once. lookupKey returns the API key of a frontend, or ErrFrontendNotFound
+func RequireSignature(lookupKey func(ctx context.Context, frontend string) (string, error), maxAge time.Duration) func(http.HandlerFunc) http.HandlerFunc {
+	replays := &replayCache{buckets: make(map[int64]map[string]struct{})}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			unauthorized := func(reason string) {
+				helper.ErrorResponse(w, "Unauthorized: "+reason, http.StatusUnauthorized)
+				logging.LogCtx(r.Context(), "WARNING", "request to "+r.URL.Path+" from "+r.RemoteAddr+" refused: "+reason)
+			}
+			frontend := r.Header.Get(FrontendHeader)
+			timestamp := r.Header.Get(TimestampHeader)
+			signature, errSignature := hex.DecodeString(r.Header.Get(SignatureHeader))
+			if frontend == "" || timestamp == "" || errSignature != nil || len(signature) == 0 {
+				unauthorized("request not signed")
+				return
+			}
+			now := time.Now().Unix()
+			signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil || signedAt < now-int64(maxAge.Seconds()) || signedAt > now+int64(maxAge.Seconds()) {
+				unauthorized("timestamp missing or outside of the accepted window")
+				return
+			}
+			if certified := Frontend(r); certified != "" && certified != frontend {
+				unauthorized("frontend does not match its client certificate")
+				return
+			}
+			key, err := lookupKey(r.Context(), frontend)
+			if errors.Is(err, ErrFrontendNotFound) {
+				unauthorized("unknown frontend " + frontend)
+				return
+			}
+			if err != nil {
+				http.Error(w, "500 Internal Server Error.", http.StatusInternalServerError)
+				logging.LogCtx(r.Context(), "ERROR", "unable to look up frontend key: "+fmt.Sprintln(err))
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBatchBytes))
+			if err != nil {
+				helper.ErrorResponse(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			expected, _ := hex.DecodeString(Sign(key, timestamp, r.Method, r.URL.RequestURI(), body))
+			if !hmac.Equal(signature, expected) {
+				unauthorized("invalid signature")
+				return
+			}
+			if !replays.firstSeen(string(signature), now, int64(maxAge.Seconds())) {
+				unauthorized("request replayed")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next(w, r.WithContext(WithFrontend(r.Context(), frontend)))
+		}
+	}
+}
+
+// RequireAdmin Only let requests that change something through when made by one of the admins frontends, answering 403 Forbidden
+// otherwise, also to requests without an authenticated frontend. GET and HEAD requests are let through
+func RequireAdmin(admins []string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != GET && r.Method != http.MethodHead && !slices.Contains(admins, Frontend(r)) {
+				helper.ErrorResponse(w, "Forbidden: frontend is not an admin", http.StatusForbidden)
+				logging.LogCtx(r.Context(), "WARNING", r.Method+" "+r.URL.Path+" refused, "+strconv.Quote(Frontend(r))+" is not an admin")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// RequireDatabase Answer 503 Service Unavailable, without calling the handler, while available reports the database is unavailable
+func RequireDatabase(available func() bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !available() {
+				w.Header().Set("Retry-After", "5")
+				helper.ErrorResponse(w, "Service Unavailable: database unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+	"zehd-backend/internal/logging"
+
+	. "zehd-backend/internal"
+)
+
+// maxRequestIDLength the longest request ID taken from a frontend, longer ones are replaced
+const maxRequestIDLength = 128
+
+// AssignRequestID Give each request an ID, taken from the X-Request-ID header when the frontend sent a valid one, and echo it in the
+// response. Lines logged with the request's context carry the ID, method, path and latency
+func AssignRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		ctx = logging.WithFields(ctx, "requestID", requestID, "method", r.Method, "path", r.URL.Path)
+		next(w, r.WithContext(logging.WithStart(ctx, start)))
+	}
+}
+
+// RequestID The ID of the request, empty outside of AssignRequestID
+func RequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+	return requestID
+}
+
+// validRequestID Request IDs from frontends end up in log lines, so only printable ASCII without spaces or quotes is accepted
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		// crypto/rand does not fail on supported platforms, the time still tells requests apart
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,330 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"zehd-backend/internal/internaldb"
+	"zehd-backend/internal/logging"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config Rules engine configuration, read from a YAML or JSON file. Durations are written as strings, e.g. "90s" or "24h"
+type Config struct {
+	// Interval how often newly collected rows are scanned
+	Interval time.Duration `yaml:"interval"`
+	// BatchSize how many rows are evaluated together. Request rates are counted across batches
+	BatchSize int `yaml:"batchSize"`
+	// BanDuration how long automatic bans last. Zero bans permanently
+	BanDuration time.Duration `yaml:"banDuration"`
+	RateLimit   RateLimit     `yaml:"rateLimit"`
+	// Paths regular expressions matched against requested paths, e.g. "^/wp-admin" or "/\\.env$"
+	Paths []string `yaml:"paths"`
+	// UserAgents regular expressions matched against user agents, e.g. "(?i)sqlmap"
+	UserAgents []string `yaml:"userAgents"`
+}
+
+// RateLimit Bans an IP that sends more than MaxRequests within Window. A zero MaxRequests disables the rule. Collected rows are timed in
+// seconds, so Window must be at least a second
+type RateLimit struct {
+	Window      time.Duration `yaml:"window"`
+	MaxRequests int           `yaml:"maxRequests"`
+}
+
+// Verdict An IP the engine decided to ban, with the reason and every collected row of that IP in the batch. Rows of earlier batches
+// counted towards the rate limit are not listed, they were marked checked with their own batch
+type Verdict struct {
+	IP           string
+	FrontendName string
+	Reason       string
+	IDs          []int64
+}
+
+// Engine Evaluates collected traffic against the configured rules
+type Engine struct {
+	config     Config
+	paths      []*regexp.Regexp
+	userAgents []*regexp.Regexp
+
+	// mu guards recent, the unix times of each IP's hits still within the rate limit window of its latest hit, kept between batches
+	mu     sync.Mutex
+	recent map[string][]int64
+}
+
+// default configuration values, used for anything left out of the rules file
+const (
+	defaultInterval  = time.Minute
+	defaultBatchSize = 1000
+	defaultWindow    = time.Minute
+)
+
+// LoadConfig Read the rules configuration from a YAML or JSON file
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return config, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// New Create an engine, compiling the configured patterns and filling in defaults
+func New(config Config) (*Engine, error) {
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.RateLimit.Window <= 0 {
+		config.RateLimit.Window = defaultWindow
+	}
+	if config.RateLimit.Window < time.Second {
+		return nil, errors.New("rateLimit.window must be at least 1s, collected rows are timed in seconds")
+	}
+	if config.BanDuration < 0 {
+		return nil, errors.New("banDuration must not be negative")
+	}
+	engine := &Engine{config: config, recent: make(map[string][]int64)}
+	var errs []error
+	for _, pattern := range config.Paths {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid path pattern %q: %w", pattern, err))
+			continue
+		}
+		engine.paths = append(engine.paths, compiled)
+	}
+	for _, pattern := range config.UserAgents {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid user agent pattern %q: %w", pattern, err))
+			continue
+		}
+		engine.userAgents = append(engine.userAgents, compiled)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return engine, nil
+}
+
+// Evaluate Decide which IPs in the batch should be banned. Rows are expected with timeDate in unix seconds. Request rates include the
+// hits of earlier batches, so batches are expected in the order the rows were collected
+func (engine *Engine) Evaluate(hits []internaldb.CollectionData) []Verdict {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	byIP := make(map[string][]internaldb.CollectionData)
+	var order []string
+	for _, hit := range hits {
+		if _, ok := byIP[hit.IP]; !ok {
+			order = append(order, hit.IP)
+		}
+		byIP[hit.IP] = append(byIP[hit.IP], hit)
+	}
+	var verdicts []Verdict
+	for _, ip := range order {
+		ipHits := byIP[ip]
+		reason := engine.probeReason(ipHits)
+		if reason == "" {
+			reason = engine.rateReason(ipHits)
+		}
+		if reason == "" {
+			continue
+		}
+		// the IP is banned, its hits need not be counted any longer
+		delete(engine.recent, ip)
+		verdict := Verdict{IP: ip, FrontendName: ipHits[0].FrontendName, Reason: reason}
+		for _, hit := range ipHits {
+			verdict.IDs = append(verdict.IDs, hit.ID)
+		}
+		verdicts = append(verdicts, verdict)
+	}
+	engine.forgetQuiet(hits)
+	return verdicts
+}
+
+// forgetQuiet drops the kept hits of IPs that sent nothing within the rate limit window before the batch's latest hit
+func (engine *Engine) forgetQuiet(hits []internaldb.CollectionData) {
+	var latest int64
+	for _, hit := range hits {
+		latest = max(latest, hit.TimeDate)
+	}
+	window := int64(engine.config.RateLimit.Window / time.Second)
+	for ip, times := range engine.recent {
+		if latest-times[len(times)-1] >= window {
+			delete(engine.recent, ip)
+		}
+	}
+}
+
+// probeReason returns why the hits look like probing, or an empty string if they do not
+func (engine *Engine) probeReason(hits []internaldb.CollectionData) string {
+	for _, hit := range hits {
+		for _, pattern := range engine.paths {
+			if pattern.MatchString(hit.Path) {
+				return "probing path " + hit.Path
+			}
+		}
+		for _, pattern := range engine.userAgents {
+			if pattern.MatchString(hit.UserAgent) {
+				return "suspicious user agent " + hit.UserAgent
+			}
+		}
+	}
+	return ""
+}
+
+// rateReason returns why the hits, along with the IP's hits kept from earlier batches, exceed the rate limit, or an empty string if
+// they do not. The hits within the window of the latest one are kept for the next batch
+func (engine *Engine) rateReason(hits []internaldb.CollectionData) string {
+	limit := engine.config.RateLimit
+	if limit.MaxRequests <= 0 {
+		return ""
+	}
+	ip := hits[0].IP
+	times := slices.Clone(engine.recent[ip])
+	for _, hit := range hits {
+		times = append(times, hit.TimeDate)
+	}
+	slices.Sort(times)
+	window := int64(limit.Window / time.Second)
+	latest := times[len(times)-1]
+	kept := sort.Search(len(times), func(i int) bool { return latest-times[i] < window })
+	engine.recent[ip] = times[kept:]
+	if len(times) <= limit.MaxRequests {
+		return ""
+	}
+	start := 0
+	for end := range times {
+		for start < end && times[end]-times[start] >= window {
+			start++
+		}
+		if end-start+1 > limit.MaxRequests {
+			return "request rate exceeded " + strconv.Itoa(limit.MaxRequests) + " requests per " + limit.Window.String()
+		}
+	}
+	return ""
+}
+
+// RunOnce Evaluate every unchecked row, batch by batch, banning offending IPs and marking the rows as checked, until ctx is cancelled.
+// Returns how many rows were checked and IPs banned
+func (engine *Engine) RunOnce(ctx context.Context) (checked int, banned int, err error) {
+	defer logging.TrackTime("RulesRunOnce", time.Now())
+	for {
+		hits, errFetch := internaldb.FetchUnchecked(ctx, engine.config.BatchSize)
+		if errFetch != nil {
+			return checked, banned, errFetch
+		}
+		if len(hits) == 0 {
+			return checked, banned, nil
+		}
+		var bannedIDs []int64
+		for _, verdict := range engine.Evaluate(hits) {
+			isNew, errBan := engine.ban(ctx, verdict)
+			if errBan != nil {
+				return checked, banned, errBan
+			}
+			if isNew {
+				banned++
+			}
+			bannedIDs = append(bannedIDs, verdict.IDs...)
+		}
+		errMark := internaldb.MarkChecked(ctx, hitIDs(hits), bannedIDs, checkedIPs(hits))
+		if errMark != nil {
+			return checked, banned, errMark
+		}
+		checked += len(hits)
+		if len(hits) < engine.config.BatchSize {
+			return checked, banned, nil
+		}
+	}
+}
+
+// ban bans the verdict's IP, unless it is invalid or already banned. isNew reports if a ban was created
+func (engine *Engine) ban(ctx context.Context, verdict Verdict) (isNew bool, err error) {
+	if _, errAddr := netip.ParseAddr(verdict.IP); errAddr != nil {
+		logging.LogIt("rulesEngine", "WARNING", "not banning invalid ip address: "+verdict.IP)
+		return false, nil
+	}
+	var existing internaldb.BannedData
+	err = existing.BannedCheck(ctx, verdict.IP)
+	if err != nil || existing.Banned {
+		return false, err
+	}
+	now := time.Now()
+	bannedData := internaldb.BannedData{
+		IP:              verdict.IP,
+		DomainName:      verdict.FrontendName,
+		Reason:          verdict.Reason,
+		TimeDateChecked: now.Unix(),
+	}
+	if engine.config.BanDuration > 0 {
+		bannedData.ExpiresAt = now.Add(engine.config.BanDuration).Unix()
+	}
+	err = bannedData.InsertBan(ctx)
+	if err != nil {
+		return false, err
+	}
+	logging.LogIt("rulesEngine", "INFO", verdict.IP+" has been banned automatically: "+verdict.Reason)
+	return true, nil
+}
+
+// Start Run the engine every configured interval, in the background, until ctx is cancelled
+func (engine *Engine) Start(ctx context.Context) {
+	internaldb.Go(func() {
+		ticker := time.NewTicker(engine.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checked, banned, err := engine.RunOnce(ctx)
+				if err != nil {
+					logging.LogIt("rulesEngine", "ERROR", "rules run failed: "+fmt.Sprintln(err))
+				}
+				if banned > 0 {
+					logging.LogIt("rulesEngine", "INFO", strconv.Itoa(checked)+" rows checked, "+strconv.Itoa(banned)+" ips banned")
+				}
+			}
+		}
+	})
+}
+
+func hitIDs(hits []internaldb.CollectionData) []int64 {
+	ids := make([]int64, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	return ids
+}
+
+// checkedIPs returns one checked_table entry per distinct ip and frontend in the batch
+func checkedIPs(hits []internaldb.CollectionData) []internaldb.CheckedData {
+	now := time.Now().Unix()
+	seen := make(map[[2]string]bool)
+	var checked []internaldb.CheckedData
+	for _, hit := range hits {
+		key := [2]string{hit.IP, hit.FrontendName}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		checked = append(checked, internaldb.CheckedData{IP: hit.IP, DomainName: hit.FrontendName, TimeDateChecked: now})
+	}
+	return checked
+}
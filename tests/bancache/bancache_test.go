@@ -0,0 +1,79 @@
+package bancache_test
+
+import (
+	"net/netip"
+	"testing"
+	"zehd-backend/internal/bancache"
+)
+
+// TestTreeLookup Checks that lookups return the most specific matching prefix, for both IPv4 and IPv6
+func TestTreeLookup(t *testing.T) {
+	tree := bancache.NewTree[string]()
+	tree.Insert(netip.MustParsePrefix("192.0.2.0/24"), "v4 /24")
+	tree.Insert(netip.MustParsePrefix("192.0.2.128/25"), "v4 /25")
+	tree.Insert(netip.MustParsePrefix("198.51.100.7/32"), "v4 host")
+	tree.Insert(netip.MustParsePrefix("2001:db8::/64"), "v6 /64")
+	tree.Insert(netip.MustParsePrefix("::ffff:203.0.113.0/120"), "mapped /24")
+
+	tests := []struct {
+		addr     string
+		expected string
+		found    bool
+	}{
+		{"192.0.2.1", "v4 /24", true},
+		{"192.0.2.200", "v4 /25", true},
+		{"198.51.100.7", "v4 host", true},
+		{"198.51.100.8", "", false},
+		{"2001:db8::1234", "v6 /64", true},
+		{"2001:db8:0:1::1", "", false},
+		{"::ffff:192.0.2.1", "v4 /24", true},
+		{"203.0.113.9", "mapped /24", true},
+	}
+	for _, tt := range tests {
+		value, found := tree.Lookup(netip.MustParseAddr(tt.addr), nil)
+		if found != tt.found || value != tt.expected {
+			t.Errorf("Lookup(%s) = %q, %v, expected %q, %v", tt.addr, value, found, tt.expected, tt.found)
+		}
+	}
+	if tree.Len() != 5 {
+		t.Errorf("expected 5 values, got %d", tree.Len())
+	}
+}
+
+// TestTreeLookupMatch Checks that values rejected by match are skipped in favour of less specific prefixes
+func TestTreeLookupMatch(t *testing.T) {
+	tree := bancache.NewTree[int]()
+	tree.Insert(netip.MustParsePrefix("10.0.0.0/8"), 1)
+	tree.Insert(netip.MustParsePrefix("10.1.0.0/16"), 2)
+	tree.Insert(netip.MustParsePrefix("10.1.0.0/16"), 3)
+
+	value, found := tree.Lookup(netip.MustParseAddr("10.1.2.3"), nil)
+	if !found || value != 3 {
+		t.Errorf("expected the last inserted value 3, got %d, %v", value, found)
+	}
+	value, found = tree.Lookup(netip.MustParseAddr("10.1.2.3"), func(v int) bool { return v != 3 })
+	if !found || value != 2 {
+		t.Errorf("expected 2 when 3 is rejected, got %d, %v", value, found)
+	}
+	value, found = tree.Lookup(netip.MustParseAddr("10.1.2.3"), func(v int) bool { return v == 1 })
+	if !found || value != 1 {
+		t.Errorf("expected the /8 when both /16 values are rejected, got %d, %v", value, found)
+	}
+}
+
+// TestCacheReady Checks that an empty cache misses until a tree is loaded
+func TestCacheReady(t *testing.T) {
+	var cache bancache.Cache[string]
+	if cache.Ready() {
+		t.Fatal("cache should not be ready before a tree is loaded")
+	}
+	if _, found := cache.Lookup(netip.MustParseAddr("192.0.2.1"), nil); found {
+		t.Fatal("lookup on an unloaded cache should miss")
+	}
+	tree := bancache.NewTree[string]()
+	tree.Insert(netip.MustParsePrefix("0.0.0.0/0"), "everything")
+	cache.Replace(tree)
+	if value, found := cache.Lookup(netip.MustParseAddr("192.0.2.1"), nil); !cache.Ready() || !found || value != "everything" {
+		t.Errorf("expected a hit after loading, got %q, %v", value, found)
+	}
+}
@@ -0,0 +1,166 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"zehd-backend/internal/certs"
+	"zehd-backend/internal/middleware"
+)
+
+// issue creates a certificate for commonName signed by parent (self-signed if nil), returning it with its key
+func issue(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writePair writes the certificate and key as PEM files into dir, returning their paths
+func writePair(t *testing.T, dir, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// TestMutualTLS Checks that only clients presenting a certificate signed by the client CA get through, named after its common name
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issue(t, "zehd CA", nil, nil)
+	caFile, _ := writePair(t, dir, "ca", ca, caKey)
+	serverCert, serverKey := issue(t, "backend", ca, caKey)
+	certFile, keyFile := writePair(t, dir, "server", serverCert, serverKey)
+	reloader, err := certs.NewReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(middleware.RequireClientCert(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, middleware.Frontend(r))
+	}))
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	frontendCert, frontendKey := issue(t, "frontend-1", ca, caKey)
+	strangerCA, strangerCAKey := issue(t, "other CA", nil, nil)
+	strangerCert, strangerKey := issue(t, "stranger", strangerCA, strangerCAKey)
+	clients := []struct {
+		name   string
+		cert   *x509.Certificate
+		key    *ecdsa.PrivateKey
+		status int
+		body   string
+	}{
+		{"signed", frontendCert, frontendKey, http.StatusOK, "frontend-1"},
+		{"none", nil, nil, http.StatusUnauthorized, ""},
+	}
+	for _, client := range clients {
+		config := &tls.Config{RootCAs: roots}
+		if client.cert != nil {
+			config.Certificates = []tls.Certificate{{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}}
+		}
+		response, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: config}}).Get(server.URL)
+		if err != nil {
+			t.Fatalf("%s: %v", client.name, err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != client.status || (client.body != "" && string(body) != client.body) {
+			t.Errorf("%s: got %d %s", client.name, response.StatusCode, body)
+		}
+	}
+
+	// sent regardless of the CAs the server asks for, which the client would otherwise refuse to do
+	config := &tls.Config{RootCAs: roots, GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &tls.Certificate{Certificate: [][]byte{strangerCert.Raw}, PrivateKey: strangerKey}, nil
+	}}
+	_, err = (&http.Client{Transport: &http.Transport{TLSClientConfig: config}}).Get(server.URL)
+	if err == nil {
+		t.Error("expected a client certificate from another CA to be refused")
+	}
+}
+
+// TestReload Checks that changed certificate files are picked up, and unchanged ones are not reloaded
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	first, firstKey := issue(t, "first", nil, nil)
+	certFile, keyFile := writePair(t, dir, "server", first, firstKey)
+	reloader, err := certs.NewReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := reloader.Reload(); reloaded || err != nil {
+		t.Errorf("expected nothing to reload, got %v, %v", reloaded, err)
+	}
+
+	second, secondKey := issue(t, "second", nil, nil)
+	writePair(t, dir, "server", second, secondKey)
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reloaded, err := reloader.Reload(); !reloaded || err != nil {
+		t.Fatalf("expected the new certificate to be loaded, got %v, %v", reloaded, err)
+	}
+	config, err := reloader.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	served, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil || served.Subject.CommonName != "second" {
+		t.Errorf("expected the new certificate to be served, got %v, %v", served.Subject.CommonName, err)
+	}
+}
@@ -0,0 +1,120 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"zehd-backend/internal"
+	"zehd-backend/internal/config"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// lookup returns a lookupEnv function reading from env only, so the process environment does not leak into tests
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+// TestLoadPrecedence Checks that the environment overrides the config file, and flags override the environment
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "listen: \":9000\"\ndb:\n  host: filehost\n  port: \"5432\"\n  user: fileuser\n  password: secret\n  name: zehd\nrollups:\n  interval: 10m\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"DBHOST": "envhost", "DBUSER": "envuser", "BANCACHE": "false"}
+	args := []string{"--config", path, "--env-file", "", "--db-user", "flaguser", "migrate", "status"}
+	conf, rest, err := config.Load(args, lookup(env))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.Listen != ":9000" || conf.DB.Host != "envhost" || conf.DB.User != "flaguser" || conf.DB.Name != "zehd" {
+		t.Errorf("wrong precedence: %+v", conf)
+	}
+	if conf.Bans.Cache || conf.Rollups.Interval != 10*time.Minute || conf.Bans.SweepInterval != time.Minute {
+		t.Errorf("wrong toggles or intervals: %+v", conf)
+	}
+	if strings.Join(rest, " ") != "migrate status" {
+		t.Errorf("expected the command to be left over, got %v", rest)
+	}
+}
+
+// TestLoadReportsEveryProblem Checks that every missing or invalid field is reported at once
+func TestLoadReportsEveryProblem(t *testing.T) {
+	env := map[string]string{"DBPORT": "postgres", "TLSCERT": "cert.pem", "LOGLEVEL": "LOUD", "LOGFORMAT": "xml", "ROLLUPINTERVAL": "often", "ROLLUPS": "false", "RETENTIONRAW": "720h"}
+	_, _, err := config.Load([]string{"--env-file", ""}, lookup(env))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"db.host", "db.user", "db.password", "db.name", "db.port must be a port number", "tls.certFile", "log.level", "log.format", "ROLLUPINTERVAL", "retention.raw"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q to be reported, got:\n%v", want, err)
+		}
+	}
+}
+
+// TestLoadUnknownKey Checks that typos in the config file are reported
+func TestLoadUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("db:\n  hots: localhost\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := config.Load([]string{"--config", path, "--env-file", ""}, lookup(nil))
+	if err == nil || !strings.Contains(err.Error(), "hots") {
+		t.Errorf("expected the unknown key to be reported, got %v", err)
+	}
+}
+
+// TestConnString Checks that SSL settings are applied to both connection string forms, and that values survive quoting
+func TestConnString(t *testing.T) {
+	keyword := internal.DBConfig{Host: "db", Port: "5432", User: "zehd", Password: `it's \ secret`, Name: "zehd", SSLMode: "require"}
+	connString, err := keyword.ConnString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := pgx.ParseConfig(connString)
+	if err != nil {
+		t.Fatalf("unable to parse %q: %v", connString, err)
+	}
+	if parsed.Password != keyword.Password || parsed.Host != "db" || parsed.TLSConfig == nil {
+		t.Errorf("wrong connection settings from %q", connString)
+	}
+
+	withURL := internal.DBConfig{URL: "postgres://zehd:secret@db:5432/zehd?sslmode=verify-full&application_name=zehd", SSLMode: "disable"}
+	connString, err = withURL.ConnString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = pgx.ParseConfig(connString)
+	if err != nil {
+		t.Fatalf("unable to parse %q: %v", connString, err)
+	}
+	if parsed.TLSConfig != nil || parsed.RuntimeParams["application_name"] != "zehd" {
+		t.Errorf("expected sslmode to be overridden and other parameters kept, got %q", connString)
+	}
+}
+
+// TestLoadDatabaseURL Checks that DATABASE_URL replaces the individual database settings
+func TestLoadDatabaseURL(t *testing.T) {
+	env := map[string]string{"DATABASE_URL": "postgres://zehd@db/zehd", "DBSSLMODE": "verify-full"}
+	conf, _, err := config.Load([]string{"--env-file", ""}, lookup(env))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.DB.URL != env["DATABASE_URL"] || conf.DB.SSLMode != "verify-full" {
+		t.Errorf("wrong database settings: %+v", conf.DB)
+	}
+	env["DBSSLMODE"] = "always"
+	env["DBSSLCERT"] = "client.pem"
+	_, _, err = config.Load([]string{"--env-file", ""}, lookup(env))
+	for _, want := range []string{"db.sslMode", "db.sslCert and db.sslKey"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q to be reported, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package handlers_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"zehd-backend/internal"
+)
+
+// statement a query or exec the fake database received, or BEGIN, COMMIT and ROLLBACK
+type statement struct {
+	query string
+	args  []driver.Value
+}
+
+// reply what the fake database answers a statement with: rows for queries, the number of affected rows for execs
+type reply struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+// fakeDB stands in for postgres, recording every statement and answering it with respond
+type fakeDB struct {
+	mu         sync.Mutex
+	statements []statement
+	respond    func(query string, args []driver.Value) reply
+}
+
+// useDB points the backend at a fake database answering with respond, until the test ends
+func useDB(t *testing.T, respond func(query string, args []driver.Value) reply) *fakeDB {
+	db := &fakeDB{respond: respond}
+	previous := internal.Db
+	internal.Db = sql.OpenDB(db)
+	t.Cleanup(func() {
+		internal.Db.Close()
+		internal.Db = previous
+	})
+	return db
+}
+
+// sent the statements received so far
+func (db *fakeDB) sent() []statement {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]statement(nil), db.statements...)
+}
+
+func (db *fakeDB) record(query string, named []driver.NamedValue) reply {
+	args := make([]driver.Value, len(named))
+	for i, value := range named {
+		args[i] = value.Value
+	}
+	db.mu.Lock()
+	db.statements = append(db.statements, statement{query, args})
+	db.mu.Unlock()
+	if db.respond == nil {
+		return reply{}
+	}
+	return db.respond(query, args)
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (conn *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+func (conn *fakeConn) Close() error { return nil }
+func (conn *fakeConn) Begin() (driver.Tx, error) {
+	conn.db.record("BEGIN", nil)
+	return conn, nil
+}
+func (conn *fakeConn) Commit() error {
+	conn.db.record("COMMIT", nil)
+	return nil
+}
+func (conn *fakeConn) Rollback() error {
+	conn.db.record("ROLLBACK", nil)
+	return nil
+}
+
+func (conn *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	answer := conn.db.record(query, args)
+	return driver.RowsAffected(answer.affected), answer.err
+}
+
+func (conn *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	answer := conn.db.record(query, args)
+	if answer.err != nil {
+		return nil, answer.err
+	}
+	return &fakeRows{columns: answer.columns, rows: answer.rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (rows *fakeRows) Columns() []string { return rows.columns }
+func (rows *fakeRows) Close() error      { return nil }
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if len(rows.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, rows.rows[0])
+	rows.rows = rows.rows[1:]
+	return nil
+}
@@ -0,0 +1,343 @@
+package handlers_test
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"zehd-backend/internal/handlers"
+	"zehd-backend/internal/internaldb"
+)
+
+// TestCollectBatchHandlerRejectsRecords Checks that invalid records are rejected individually and summarised
+func TestCollectBatchHandlerRejectsRecords(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"json array", "application/json", `[{"ip": ""}, {"ip": "192.0.2.1", "port": "not a number"}]`},
+		{"ndjson", "application/x-ndjson", "{\"ip\": \"\"}\n\n{\"ip\": \"192.0.2.1\", \"port\": \"80\"}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/collect/batch", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+
+			handlers.CollectBatchHandler(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+			}
+			var summary internaldb.BatchSummary
+			if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+				t.Fatalf("unable to decode summary: %v", err)
+			}
+			if summary.Accepted != 0 || summary.Rejected != 2 || len(summary.Results) != 2 {
+				t.Fatalf("unexpected summary: %+v", summary)
+			}
+			if summary.Results[1].Error != "wrong type provided for field: port" {
+				t.Errorf("unexpected error for record 1: %q", summary.Results[1].Error)
+			}
+		})
+	}
+}
+
+// TestHandlersRefuseBadRequests Checks that malformed requests are refused before reaching the database
+func TestHandlersRefuseBadRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		body    string
+		status  int
+		message string
+	}{
+		{"batch wrong method", handlers.CollectBatchHandler, http.MethodGet, "/api/collect/batch", "", http.StatusMethodNotAllowed, ""},
+		{"batch malformed array", handlers.CollectBatchHandler, http.MethodPost, "/api/collect/batch", "{", http.StatusBadRequest, ""},
+		{"ban invalid ip", handlers.BannedHandler, http.MethodPost, "/api/banned", `{"ip": "not-an-ip"}`, http.StatusBadRequest, ""},
+		{"ban negative expiry", handlers.BannedHandler, http.MethodPost, "/api/banned", `{"ip": "192.0.2.1", "expiresAt": -1}`, http.StatusBadRequest, ""},
+		{"lift without ip", handlers.BannedHandler, http.MethodDelete, "/api/banned", "", http.StatusBadRequest, ""},
+		{"bans invalid page", handlers.BannedHandler, http.MethodGet, "/api/banned?page=0", "", http.StatusBadRequest, ""},
+		{"collected invalid filters", handlers.CollectedHandler, http.MethodGet, "/api/collected?from=yesterday&to=-1&cursor=abc", "", http.StatusBadRequest,
+			"Bad Request: cursor must be a non-negative number, from must be a non-negative number, to must be a non-negative number"},
+		{"stats unknown group", handlers.StatsHandler, http.MethodGet, "/api/stats/colours", "", http.StatusNotFound, ""},
+		{"stats too many buckets", handlers.StatsHandler, http.MethodGet, "/api/stats/timeseries?window=8760h&bucket=minute", "", http.StatusBadRequest, ""},
+		{"frontends invalid silentAfter", handlers.FrontendsHandler, http.MethodGet, "/api/frontends?silentAfter=soon", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			tt.handler(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rec.Code)
+			}
+			if tt.message == "" {
+				return
+			}
+			var response map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("unable to decode response: %v", err)
+			}
+			if response["message"] != tt.message {
+				t.Errorf("expected message %q, got %q", tt.message, response["message"])
+			}
+		})
+	}
+}
+
+// TestCollectBatchHandlerChunks Checks that large batches are inserted in chunks within a single transaction, and rejected as a whole
+// when a chunk fails
+func TestCollectBatchHandlerChunks(t *testing.T) {
+	body := strings.Repeat(`{"ip": "192.0.2.1", "path": "/"}`+"\n", 2500)
+	for _, failing := range []bool{false, true} {
+		inserts := 0
+		db := useDB(t, func(query string, args []driver.Value) reply {
+			if strings.HasPrefix(query, "INSERT") {
+				inserts++
+				if failing && inserts == 2 {
+					return reply{err: errors.New("connection reset")}
+				}
+			}
+			return reply{}
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/collect/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		rec := httptest.NewRecorder()
+
+		handlers.CollectBatchHandler(rec, req)
+
+		var rows []int
+		var sent []string
+		for _, stmt := range db.sent() {
+			sent = append(sent, strings.Fields(stmt.query)[0])
+			if strings.HasPrefix(stmt.query, "INSERT") {
+				rows = append(rows, len(stmt.args)/14)
+			}
+		}
+		var summary internaldb.BatchSummary
+		if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+			t.Fatalf("unable to decode summary: %v", err)
+		}
+		if !failing {
+			if rec.Code != http.StatusOK || summary.Accepted != 2500 || fmt.Sprint(rows) != "[1000 1000 500]" || sent[len(sent)-1] != "COMMIT" {
+				t.Errorf("expected 3 chunks committed, got %d %v %v accepted %d", rec.Code, sent, rows, summary.Accepted)
+			}
+			continue
+		}
+		if rec.Code != http.StatusInternalServerError || summary.Rejected != 2500 || fmt.Sprint(rows) != "[1000 1000]" || sent[len(sent)-1] != "ROLLBACK" {
+			t.Errorf("expected the batch to be rolled back and rejected, got %d %v %v rejected %d", rec.Code, sent, rows, summary.Rejected)
+		}
+	}
+}
+
+// TestCollectedHandlerPagination Checks the filters sent to the database and that nextCursor points at the last row of a full page
+func TestCollectedHandlerPagination(t *testing.T) {
+	columns := make([]string, 14)
+	row := func(id int64) []driver.Value {
+		return []driver.Value{id, "web-1", "192.0.2.1", int64(443), "/", "GET", "", "", "curl/8.0", "", "", int64(1700000000), "NL", int64(200)}
+	}
+	db := useDB(t, func(query string, args []driver.Value) reply {
+		return reply{columns: columns, rows: [][]driver.Value{row(99), row(98), row(97)}}
+	})
+	tests := []struct {
+		target     string
+		where      string
+		args       string
+		rows       int
+		nextCursor int64
+	}{
+		{"/api/collected?frontend=web-1&from=1700000000&cursor=100&limit=2",
+			" WHERE frontend = $1 AND timedate >= $2 AND unique_id < $3 ORDER BY unique_id DESC LIMIT $4;", "[web-1 1700000000 100 3]", 2, 98},
+		{"/api/collected?limit=5", " ORDER BY unique_id DESC LIMIT $1;", "[6]", 3, 0},
+	}
+	for i, tt := range tests {
+		rec := httptest.NewRecorder()
+		handlers.CollectedHandler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		stmt := db.sent()[i]
+		if !strings.HasSuffix(stmt.query, "FROM collect_table"+tt.where) || fmt.Sprint(stmt.args) != tt.args {
+			t.Errorf("%s: unexpected query %q %v", tt.target, stmt.query, stmt.args)
+		}
+		var page internaldb.CollectedPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("%s: unable to decode page: %v", tt.target, err)
+		}
+		if rec.Code != http.StatusOK || len(page.Data) != tt.rows || page.NextCursor != tt.nextCursor {
+			t.Errorf("%s: expected %d rows and cursor %d, got %d %+v", tt.target, tt.rows, tt.nextCursor, rec.Code, page)
+		}
+	}
+}
+
+// TestBannedHandlerAdmin Checks that bans are added for the canonical network, lifted, and listed a page at a time
+func TestBannedHandlerAdmin(t *testing.T) {
+	var lifted int64
+	db := useDB(t, func(query string, args []driver.Value) reply {
+		switch {
+		case strings.Contains(query, "RETURNING unique_id"):
+			return reply{columns: []string{"unique_id", "network"}, rows: [][]driver.Value{{int64(7), "192.0.2.0/24"}}}
+		case strings.HasPrefix(query, "DELETE"):
+			return reply{affected: lifted}
+		case strings.HasPrefix(query, "SELECT count(*)"):
+			return reply{columns: []string{"count"}, rows: [][]driver.Value{{int64(25)}}}
+		case strings.HasPrefix(query, "SELECT"):
+			return reply{columns: make([]string, 8), rows: [][]driver.Value{{int64(5), "192.0.2.1", "192.0.2.1/32", "", "scanner", int64(0), int64(1700000000), int64(0)}}}
+		}
+		return reply{}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/banned", strings.NewReader(`{"ip": "192.0.2.77/24", "reason": "scanner", "duration": "1h"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handlers.BannedHandler(rec, req)
+	var ban internaldb.BannedData
+	if err := json.Unmarshal(rec.Body.Bytes(), &ban); err != nil {
+		t.Fatalf("add: unable to decode ban: %v", err)
+	}
+	insert := db.sent()[0]
+	if rec.Code != http.StatusCreated || ban.ID != 7 || !ban.Banned || insert.args[0] != "192.0.2.0/24" || insert.args[2] != "scanner" || insert.args[5].(int64) <= time.Now().Unix() {
+		t.Errorf("add: unexpected ban %d %+v from %v", rec.Code, ban, insert.args)
+	}
+
+	for _, tt := range []struct {
+		lifted int64
+		status int
+	}{{1, http.StatusOK}, {0, http.StatusNotFound}} {
+		lifted = tt.lifted
+		rec = httptest.NewRecorder()
+		handlers.BannedHandler(rec, httptest.NewRequest(http.MethodDelete, "/api/banned?ip=192.0.2.1&domainname=example.org", nil))
+		sent := db.sent()
+		if lift := sent[len(sent)-1]; rec.Code != tt.status || fmt.Sprint(lift.args) != "[192.0.2.1/32 example.org]" {
+			t.Errorf("lift: expected status %d, got %d with %v", tt.status, rec.Code, lift.args)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handlers.BannedHandler(rec, httptest.NewRequest(http.MethodGet, "/api/banned?page=3&limit=10", nil))
+	var list internaldb.BannedList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("list: unable to decode bans: %v", err)
+	}
+	sent := db.sent()
+	if page := sent[len(sent)-1]; !strings.HasSuffix(page.query, "LIMIT $1 OFFSET $2;") || fmt.Sprint(page.args) != "[10 20]" {
+		t.Errorf("list: unexpected query %q %v", page.query, page.args)
+	}
+	if rec.Code != http.StatusOK || list.Total != 25 || list.Page != 3 || len(list.Bans) != 1 || list.Bans[0].Prefix != "192.0.2.1/32" {
+		t.Errorf("list: unexpected page %d %+v", rec.Code, list)
+	}
+}
+
+// TestStatsHandlerGrouping Checks which table each group and window is aggregated from, the default window included, and how rows
+// are returned
+func TestStatsHandlerGrouping(t *testing.T) {
+	tests := []struct {
+		target   string
+		contains []string
+		args     string
+		rows     [][]driver.Value
+		want     internaldb.StatsRow
+	}{
+		{"/api/stats/paths?from=1700000000&to=1700005400&frontend=web-1",
+			[]string{"SELECT COALESCE(path, '') AS key, count(*) FROM collect_table WHERE", "LIMIT $4"},
+			"[1700000000 1700005400 web-1 10]", [][]driver.Value{{"/", int64(3)}}, internaldb.StatsRow{Key: "/", Hits: 3}},
+		{"/api/stats/paths?from=1700000000&to=1700010000",
+			[]string{"FROM collect_rollup_hourly", "WHERE bucket >= $5 AND bucket < $6", "AND (timedate < $5 OR timedate >= $6 OR unique_id >"},
+			"[1700000000 1700010000  collect_table 1700002800 1700010000 10]", [][]driver.Value{{"/", int64(4)}}, internaldb.StatsRow{Key: "/", Hits: 4}},
+		{"/api/stats/frontends", []string{"FROM collect_rollup_hourly", "SELECT frontend AS key"},
+			"", [][]driver.Value{{"web-1", int64(9)}}, internaldb.StatsRow{Key: "web-1", Hits: 9}},
+		{"/api/stats/countries?from=1699920000&to=1700006400&limit=5",
+			[]string{"FROM collect_rollup_daily", "SELECT cfipcountry AS key, sum(hits)::bigint FROM hits", "LIMIT $7"},
+			"[1699920000 1700006400  collect_table 1699920000 1700006400 5]", [][]driver.Value{{"NL", int64(12)}}, internaldb.StatsRow{Key: "NL", Hits: 12}},
+		{"/api/stats/timeseries?from=1700010000&to=1700017200&bucket=hour",
+			[]string{"FROM collect_rollup_hourly", "unique_id > COALESCE((SELECT last_id FROM rollup_state WHERE name = $4), 0)", "(timedate / $7) * $7"},
+			"[1700010000 1700017200  collect_table 1700010000 1700017200 3600]", [][]driver.Value{{int64(1700010000), "web-1", int64(5)}},
+			internaldb.StatsRow{Key: "1700010000", Bucket: 1700010000, Frontend: "web-1", Hits: 5}},
+		{"/api/stats/timeseries?from=1700010000&to=1700017200&bucket=minute",
+			[]string{"FROM collect_table WHERE", "(timedate / $4) * $4"},
+			"[1700010000 1700017200  60]", [][]driver.Value{{int64(1700010060), "web-1", int64(1)}},
+			internaldb.StatsRow{Key: "1700010060", Bucket: 1700010060, Frontend: "web-1", Hits: 1}},
+		{"/api/stats/useragents?from=1699920000&to=1700006400",
+			[]string{"WHEN useragent ~* 'safari/' THEN 'Safari'", "FROM collect_table WHERE"},
+			"[1699920000 1700006400  10]", [][]driver.Value{{"Firefox", int64(2)}}, internaldb.StatsRow{Key: "Firefox", Hits: 2}},
+	}
+	for _, tt := range tests {
+		db := useDB(t, func(query string, args []driver.Value) reply {
+			return reply{columns: make([]string, len(tt.rows[0])), rows: tt.rows}
+		})
+		rec := httptest.NewRecorder()
+		handlers.StatsHandler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		stmt := db.sent()[0]
+		for _, want := range tt.contains {
+			if !strings.Contains(stmt.query, want) {
+				t.Errorf("%s: expected the query to contain %q, got %q", tt.target, want, stmt.query)
+			}
+		}
+		if tt.args != "" && fmt.Sprint(stmt.args) != tt.args {
+			t.Errorf("%s: expected arguments %s, got %v", tt.target, tt.args, stmt.args)
+		}
+		var result internaldb.StatsResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: unable to decode result: %v", tt.target, err)
+		}
+		if rec.Code != http.StatusOK || len(result.Rows) != 1 || result.Rows[0] != tt.want {
+			t.Errorf("%s: expected %+v, got %d %+v", tt.target, tt.want, rec.Code, result)
+		}
+	}
+}
+
+// TestHealthHandlersWithoutDatabase Checks that the backend reports itself alive but not ready, with the reason, without a database
+func TestHealthHandlersWithoutDatabase(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handlers.HealthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("healthz: expected status 200, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handlers.ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz: expected status 503, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handlers.StatusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status handlers.Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("status: unable to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || status.Database.Available || status.Database.Error == "" || status.Version == "" {
+		t.Errorf("status: expected the database to be reported unavailable, got %d %+v", rec.Code, status)
+	}
+}
+
+// TestTrackTimeHandler Checks the methods and query the TrackTime endpoint accepts
+func TestTrackTimeHandler(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodPost, "/debug/tracktime", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/debug/tracktime?recent=many", http.StatusBadRequest},
+		{http.MethodGet, "/debug/tracktime?task=InsertCollectedData&recent=5", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handlers.TrackTimeHandler(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.target, tt.status, w.Code)
+		}
+	}
+}
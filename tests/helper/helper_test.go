@@ -0,0 +1,37 @@
+package helper_test
+
+import (
+	"testing"
+	"zehd-backend/internal/helper"
+)
+
+// TestParseNetwork Checks that addresses and networks are turned into canonical prefixes
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"192.0.2.1", "192.0.2.1/32"},
+		{"192.0.2.77/24", "192.0.2.0/24"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8:0:0:1234::/64", "2001:db8::/64"},
+		{"::ffff:192.0.2.1", "192.0.2.1/32"},
+		{"::ffff:192.0.2.0/120", "192.0.2.0/24"},
+		{"fe80::1%eth0", "fe80::1/128"},
+	}
+	for _, tt := range tests {
+		prefix, err := helper.ParseNetwork(tt.value)
+		if err != nil {
+			t.Errorf("ParseNetwork(%q) returned error: %v", tt.value, err)
+			continue
+		}
+		if prefix.String() != tt.expected {
+			t.Errorf("ParseNetwork(%q) = %s, expected %s", tt.value, prefix, tt.expected)
+		}
+	}
+	for _, value := range []string{"", "not-an-ip", "192.0.2.1/33", "192.0.2"} {
+		if _, err := helper.ParseNetwork(value); err == nil {
+			t.Errorf("ParseNetwork(%q) expected an error", value)
+		}
+	}
+}
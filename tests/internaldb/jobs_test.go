@@ -0,0 +1,36 @@
+package internaldb_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zehd-backend/internal/internaldb"
+)
+
+// TestCloseWaitsForJobs Checks that Close waits for background jobs to return once their context is cancelled, but not forever
+func TestCloseWaitsForJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var finished atomic.Bool
+	internaldb.Go(func() {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		finished.Store(true)
+	})
+	cancel()
+	if err := internaldb.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !finished.Load() {
+		t.Error("expected Close to wait for the job to return")
+	}
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+	internaldb.Go(func() { <-stuck })
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTimeout()
+	if err := internaldb.Close(timeout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
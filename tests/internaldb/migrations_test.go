@@ -0,0 +1,45 @@
+package internaldb_test
+
+import (
+	"testing"
+	"testing/fstest"
+	"zehd-backend/internal/internaldb"
+)
+
+// TestMigrations Checks that the embedded migrations are complete and in order
+func TestMigrations(t *testing.T) {
+	migrations, err := internaldb.Migrations()
+	if err != nil {
+		t.Fatalf("unable to read embedded migrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("expected embedded migrations")
+	}
+	if migrations[0].Name != "baseline" {
+		t.Errorf("expected the first migration to be the baseline, got %s", migrations[0].Name)
+	}
+}
+
+// TestParseMigrations Checks that gaps, missing down files and conflicting names are refused
+func TestParseMigrations(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		valid bool
+	}{
+		{"complete", fstest.MapFS{"0001_a.up.sql": file, "0001_a.down.sql": file, "0002_b.up.sql": file, "0002_b.down.sql": file, "README": file}, true},
+		{"gap", fstest.MapFS{"0001_a.up.sql": file, "0001_a.down.sql": file, "0003_c.up.sql": file, "0003_c.down.sql": file}, false},
+		{"missing down", fstest.MapFS{"0001_a.up.sql": file}, false},
+		{"conflicting names", fstest.MapFS{"0001_a.up.sql": file, "0001_b.down.sql": file}, false},
+	}
+	for _, tt := range tests {
+		migrations, err := internaldb.ParseMigrations(tt.files)
+		if tt.valid && (err != nil || len(migrations) != 2 || migrations[1].Name != "b") {
+			t.Errorf("%s: expected two migrations, got %+v, %v", tt.name, migrations, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
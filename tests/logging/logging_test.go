@@ -1,10 +1,17 @@
 package logging_test
 
 import (
+	"encoding/json"
+	"errors"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"zehd-backend/internal"
 	"zehd-backend/internal/logging"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestLogIt Logit testing, to check if logs will be written/printed
@@ -45,3 +52,230 @@ func TestLogIt(t *testing.T) {
 		t.Errorf("Log message not found in file. Expected: %s, Found: %s", expectedMessage, string(fileContent))
 	}
 }
+
+// TestStructuredFormats Checks that json and logfmt lines carry the level, function, message and fields, and that lines below the
+// minimum level are dropped
+func TestStructuredFormats(t *testing.T) {
+	defer logging.Configure(internal.LogConfig{})
+	path := filepath.Join(t.TempDir(), "backend.log")
+
+	err := logging.Configure(internal.LogConfig{Level: "INFO", File: path, Format: "json", Output: "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logging.LogIt("TestFunction", "DEBUG", "dropped")
+	logging.LogFields("TestFunction", "WARNING", "slow insert\n", "rows", 5, "took", 1500*time.Millisecond, "err", errors.New("timeout"))
+	logging.Close()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]any
+	err = json.Unmarshal(content, &line)
+	if err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", content, err)
+	}
+	want := map[string]any{"level": "WARNING", "func": "TestFunction", "msg": "slow insert", "rows": 5.0, "took": "1.5s", "err": "timeout"}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, line[key])
+		}
+	}
+
+	err = logging.Configure(internal.LogConfig{File: path, Format: "logfmt", Output: "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logging.LogFields("TestFunction", "INFO", "collected data", "frontend", "web-1")
+	logging.Close()
+	content, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if !strings.HasSuffix(lines[len(lines)-1], ` level=INFO func=TestFunction msg="collected data" frontend=web-1`) {
+		t.Errorf("unexpected logfmt line %q", lines[len(lines)-1])
+	}
+}
+
+// TestRotation Checks that the log file is rotated once it reaches its size, that rotated files are compressed and that only the
+// newest backups are kept
+func TestRotation(t *testing.T) {
+	defer logging.Configure(internal.LogConfig{})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backend.log")
+	err := logging.Configure(internal.LogConfig{File: path, Output: "file", MaxSize: 1, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4MB of lines, rotated three times or more
+	message := strings.Repeat("x", 1024)
+	for i := 0; i < 4*1024; i++ {
+		logging.LogIt("TestFunction", "INFO", message)
+	}
+	logging.Close()
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("expected 2 rotated files to be kept, got %v", rotated)
+	}
+	for _, file := range rotated {
+		if !strings.HasSuffix(file, ".gz") {
+			t.Errorf("expected %s to be compressed", file)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1024*1024 {
+		t.Errorf("expected the current file to stay under 1MB, got %d bytes", info.Size())
+	}
+}
+
+// recordingSink keeps the entries written to it
+type recordingSink struct {
+	entries []logging.Entry
+}
+
+func (sink *recordingSink) Write(entry logging.Entry) error {
+	sink.entries = append(sink.entries, entry)
+	return nil
+}
+
+func (sink *recordingSink) Close() error {
+	return nil
+}
+
+// TestAddSink Checks that added sinks receive entries above the minimum level, until the sinks are closed
+func TestAddSink(t *testing.T) {
+	defer logging.Configure(internal.LogConfig{})
+	err := logging.Configure(internal.LogConfig{Level: "WARNING", File: filepath.Join(t.TempDir(), "backend.log"), Output: "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordingSink{}
+	err = logging.AddSink(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logging.LogIt("TestFunction", "INFO", "dropped")
+	logging.LogFields("TestFunction", "ERROR", "kept", "rows", 3)
+	logging.Close()
+	logging.LogIt("TestFunction", "ERROR", "after close")
+	if len(sink.entries) != 1 || sink.entries[0].Message != "kept" || sink.entries[0].Fields[0] != (logging.Field{Key: "rows", Value: 3}) {
+		t.Errorf("unexpected entries %+v", sink.entries)
+	}
+}
+
+// TestJournaldSink Checks that entries are sent with the native protocol, fields named the journal way
+func TestJournaldSink(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+	journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	sink, err := logging.NewJournaldSink(socket, "zehd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	entry := logging.Entry{Time: time.Now(), Level: "WARNING", Function: "InsertCollectedData", Message: "slow\ninsert",
+		Fields: []logging.Field{{Key: "requestID", Value: "abc"}, {Key: "latency", Value: 2 * time.Second}}}
+	err = sink.Write(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datagram := make([]byte, 4096)
+	n, err := journal.Read(datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "MESSAGE\n\x0b\x00\x00\x00\x00\x00\x00\x00slow\ninsert\nPRIORITY=4\nCODE_FUNC=InsertCollectedData\n" +
+		"SYSLOG_IDENTIFIER=zehd\nREQUEST_ID=abc\nLATENCY=2s\n"
+	if string(datagram[:n]) != want {
+		t.Errorf("expected %q, got %q", want, datagram[:n])
+	}
+}
+
+// TestSyslogSink Checks that entries are sent as RFC 5424 messages, with the fields as escaped structured data under the configured
+// enterprise ID, or appended to the message without one
+func TestSyslogSink(t *testing.T) {
+	tests := []struct {
+		name         string
+		enterpriseID string
+		want         string
+	}{
+		{"structured data", "12345",
+			`^<132>1 \S+ \S+ zehd \d+ InsertCollectedData \[fields@12345 requestID="abc" err="a \\"quoted\\" \[reason\\]"\] slow insert$`},
+		{"no enterprise ID", "",
+			`^<132>1 \S+ \S+ zehd \d+ InsertCollectedData - slow insert requestID=abc err="a \\"quoted\\" \[reason\]"$`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer daemon.Close()
+			sink, err := logging.NewSyslogSink(internal.SyslogConfig{Address: "udp://" + daemon.LocalAddr().String(), Facility: "local0",
+				Tag: "zehd", EnterpriseID: test.enterpriseID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+
+			entry := logging.Entry{Time: time.Now(), Level: "WARNING", Function: "InsertCollectedData", Message: "slow insert",
+				Fields: []logging.Field{{Key: "requestID", Value: "abc"}, {Key: "err", Value: errors.New(`a "quoted" [reason]`)}}}
+			err = sink.Write(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			message := make([]byte, 4096)
+			n, _, err := daemon.ReadFrom(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// local0 is facility 16, WARNING severity 4
+			if !regexp.MustCompile(test.want).Match(message[:n]) {
+				t.Errorf("unexpected message %q", message[:n])
+			}
+		})
+	}
+}
+
+// TestProfile Checks that only the latest measurements are kept per task, and their percentiles
+func TestProfile(t *testing.T) {
+	logging.ConfigureProfiler(true, 10)
+	defer logging.ConfigureProfiler(false, internal.DefaultProfilerSamples)
+	for i := 1; i <= 20; i++ {
+		logging.TrackTime("TestTask", time.Now().Add(-time.Duration(i)*time.Millisecond))
+	}
+	logging.TrackTime("OtherTask", time.Now())
+
+	profiles := logging.Profile(3, "TestTask")
+	if len(profiles) != 1 {
+		t.Fatalf("expected only TestTask, got %+v", profiles)
+	}
+	profile := profiles[0]
+	if profile.Count != 10 || len(profile.Recent) != 3 {
+		t.Errorf("expected 10 measurements kept and 3 listed, got %d and %d", profile.Count, len(profile.Recent))
+	}
+	within := func(name string, got, want float64) {
+		if got < want || got > want+5 {
+			t.Errorf("expected %s to be about %vms, got %vms", name, want, got)
+		}
+	}
+	within("p50", profile.P50, 15)
+	within("p95", profile.P95, 20)
+	within("p99", profile.P99, 20)
+	within("latest", profile.Recent[2], 20)
+	if len(logging.Profile(0)) != 2 {
+		t.Errorf("expected every task to be profiled")
+	}
+}
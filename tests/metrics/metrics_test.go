@@ -0,0 +1,60 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"zehd-backend/internal/metrics"
+)
+
+// TestWrite Checks counters and histograms are written in the Prometheus text exposition format, with escaped label values
+func TestWrite(t *testing.T) {
+	counter := metrics.NewCounterVec("test_events_total", "Events seen", "kind")
+	counter.Inc(`say "hi"`)
+	counter.Add(2, `say "hi"`)
+	histogram := metrics.NewHistogramVec("test_duration_seconds", "Durations", []float64{0.1, 1}, "task")
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.5, "a")
+	metrics.NewGaugeFunc("test_temperature", "Temperature", func() float64 { return 21.5 })
+
+	var output bytes.Buffer
+	metrics.Write(&output)
+	for _, want := range []string{
+		"# TYPE test_events_total counter\n",
+		`test_events_total{kind="say \"hi\""} 3` + "\n",
+		"# TYPE test_duration_seconds histogram\n",
+		`test_duration_seconds_bucket{task="a",le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{task="a",le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{task="a",le="+Inf"} 2` + "\n",
+		`test_duration_seconds_sum{task="a"} 0.55` + "\n",
+		`test_duration_seconds_count{task="a"} 2` + "\n",
+		"test_temperature 21.5\n",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, output.String())
+		}
+	}
+}
+
+// TestInstrument Checks that requests are counted by handler, method and the status they were answered with, unknown methods as other
+func TestInstrument(t *testing.T) {
+	handler := metrics.Instrument("/teapot", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/teapot", nil))
+	handler(httptest.NewRecorder(), httptest.NewRequest("BREW", "/teapot", nil))
+
+	rec := httptest.NewRecorder()
+	metrics.Handler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`zehd_http_requests_total{handler="/teapot",method="POST",status="418"} 1`,
+		`zehd_http_requests_total{handler="/teapot",method="other",status="418"} 1`,
+		`zehd_http_request_duration_seconds_count{handler="/teapot",status="418"} 2`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, rec.Body.String())
+		}
+	}
+}
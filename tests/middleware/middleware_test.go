@@ -0,0 +1,210 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"zehd-backend/internal/logging"
+	"zehd-backend/internal/middleware"
+
+	. "zehd-backend/internal"
+)
+
+// TestRequireSignature Checks that only fresh requests signed with a registered frontend's key get through, and only once
+func TestRequireSignature(t *testing.T) {
+	keys := map[string]string{"frontend-1": "secret"}
+	lookupKey := func(_ context.Context, frontend string) (string, error) {
+		key, ok := keys[frontend]
+		if !ok {
+			return "", ErrFrontendNotFound
+		}
+		return key, nil
+	}
+	handler := middleware.RequireSignature(lookupKey, time.Minute)(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, middleware.Frontend(r)+" "+string(body))
+	})
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	body := `{"ip":"192.0.2.1"}`
+	request := func(frontend, key, timestamp, signedBody string) *http.Request {
+		r := httptest.NewRequest(POST, "/api/collect?source=test", strings.NewReader(body))
+		r.Header.Set(FrontendHeader, frontend)
+		r.Header.Set(TimestampHeader, timestamp)
+		r.Header.Set(SignatureHeader, middleware.Sign(key, timestamp, POST, "/api/collect?source=test", []byte(signedBody)))
+		return r
+	}
+	tests := []struct {
+		name    string
+		request *http.Request
+		status  int
+	}{
+		{"signed", request("frontend-1", "secret", now, body), http.StatusOK},
+		{"replayed", request("frontend-1", "secret", now, body), http.StatusUnauthorized},
+		{"unsigned", httptest.NewRequest(POST, "/api/collect", strings.NewReader(body)), http.StatusUnauthorized},
+		{"wrong key", request("frontend-1", "guess", now, body), http.StatusUnauthorized},
+		{"tampered body", request("frontend-1", "secret", now, `{"ip":"192.0.2.2"}`), http.StatusUnauthorized},
+		{"stale", request("frontend-1", "secret", stale, body), http.StatusUnauthorized},
+		{"unknown frontend", request("frontend-2", "secret", now, body), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler(recorder, test.request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected %d, got %d %s", test.name, test.status, recorder.Code, recorder.Body.String())
+		}
+		if test.status == http.StatusOK && recorder.Body.String() != "frontend-1 "+body {
+			t.Errorf("%s: expected the frontend and body to reach the handler, got %q", test.name, recorder.Body.String())
+		}
+	}
+}
+
+// TestRequireDatabase Checks that requests are answered with 503 while the database is unavailable, and reach the handler once it is back
+func TestRequireDatabase(t *testing.T) {
+	available := false
+	handler := middleware.RequireDatabase(func() bool { return available })(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusNoContent} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(GET, "/api/collected", nil))
+		if recorder.Code != want {
+			t.Errorf("available %v: expected %d, got %d", available, want, recorder.Code)
+		}
+		available = true
+	}
+}
+
+// TestRequireAdmin Checks that only admins may ban or lift bans, while any frontend, or none, may check them
+func TestRequireAdmin(t *testing.T) {
+	handler := middleware.RequireAdmin([]string{"admin"})(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	request := func(method, frontend string) *http.Request {
+		r := httptest.NewRequest(method, "/api/banned", nil)
+		if frontend != "" {
+			r = r.WithContext(middleware.WithFrontend(r.Context(), frontend))
+		}
+		return r
+	}
+	tests := []struct {
+		name    string
+		request *http.Request
+		status  int
+	}{
+		{"admin bans", request(POST, "admin"), http.StatusNoContent},
+		{"admin lifts", request(DELETE, "admin"), http.StatusNoContent},
+		{"frontend bans", request(POST, "frontend-1"), http.StatusForbidden},
+		{"frontend lifts", request(DELETE, "frontend-1"), http.StatusForbidden},
+		{"unauthenticated bans", request(POST, ""), http.StatusForbidden},
+		{"frontend checks", request(GET, "frontend-1"), http.StatusNoContent},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler(recorder, test.request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected %d, got %d", test.name, test.status, recorder.Code)
+		}
+	}
+}
+
+// TestAssignRequestID Checks that valid request IDs from frontends are kept, others replaced, and that lines logged for the request
+// carry its ID, method, path, frontend and latency
+func TestAssignRequestID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backend.log")
+	err := logging.Configure(LogConfig{File: path, Format: "json", Output: "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logging.Configure(LogConfig{})
+	handler := middleware.AssignRequestID(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(middleware.WithFrontend(r.Context(), "frontend-1"))
+		logging.LogCtx(r.Context(), "INFO", "handled")
+		_, _ = io.WriteString(w, middleware.RequestID(r))
+	})
+
+	for _, sent := range []string{"", "abc-123", "has space", strings.Repeat("x", 200)} {
+		r := httptest.NewRequest(GET, "/api/collected", nil)
+		if sent != "" {
+			r.Header.Set(RequestIDHeader, sent)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assigned := w.Header().Get(RequestIDHeader)
+		if assigned == "" || assigned != w.Body.String() {
+			t.Errorf("sent %q: expected the response header %q to match the context's ID %q", sent, assigned, w.Body.String())
+		}
+		if kept := assigned == sent; kept != (sent == "abc-123") {
+			t.Errorf("sent %q, got %q", sent, assigned)
+		}
+	}
+
+	logging.Close()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]any
+	err = json.Unmarshal([]byte(strings.Split(string(content), "\n")[1]), &line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"func": "TestAssignRequestID", "requestID": "abc-123", "method": GET, "path": "/api/collected", "frontend": "frontend-1"}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, line[key])
+		}
+	}
+	if _, ok := line["latency"]; !ok {
+		t.Errorf("expected the latency to be logged, got %v", line)
+	}
+}
+
+// TestAccessLog Checks the Combined Log Format and JSON lines, and that sampling only drops successful collect requests
+func TestAccessLog(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(middleware.WithFrontend(r.Context(), "frontend-1"))
+		if r.URL.Query().Has("bad") {
+			http.Error(w, "bad", http.StatusBadRequest)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}
+	var out strings.Builder
+	combined := middleware.NewAccessLog(&out, "combined", 1).Handler(handler)
+	r := httptest.NewRequest(GET, "/api/collected?page=2", nil)
+	r.RemoteAddr = "192.0.2.1:4321"
+	r.Header.Set("User-Agent", "zehd-frontend")
+	combined(httptest.NewRecorder(), r)
+	want := regexp.MustCompile(`^192\.0\.2\.1 - frontend-1 \[[^]]+\] "GET /api/collected\?page=2 HTTP/1\.1" 200 2 "-" "zehd-frontend"\n$`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("unexpected combined line %q", out.String())
+	}
+
+	out.Reset()
+	sampled := middleware.NewAccessLog(&out, "json", 0).Handler(handler)
+	sampled(httptest.NewRecorder(), httptest.NewRequest(POST, "/api/collect", nil))
+	sampled(httptest.NewRecorder(), httptest.NewRequest(POST, "/api/collect?bad", nil))
+	sampled(httptest.NewRecorder(), httptest.NewRequest(GET, "/api/banned", nil))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected the failed collect request and the ban request to be logged, got %q", lines)
+	}
+	var entry map[string]any
+	err := json.Unmarshal([]byte(lines[0]), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry["status"] != 400.0 || entry["uri"] != "/api/collect?bad" || entry["frontend"] != "frontend-1" {
+		t.Errorf("unexpected json line %q", lines[0])
+	}
+}
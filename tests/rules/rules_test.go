@@ -0,0 +1,136 @@
+package rules_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+	"zehd-backend/internal/internaldb"
+	"zehd-backend/internal/rules"
+)
+
+// TestEvaluate Checks that probing paths, suspicious user agents and request rates each lead to a ban
+func TestEvaluate(t *testing.T) {
+	engine, err := rules.New(rules.Config{
+		RateLimit:  rules.RateLimit{Window: 10 * time.Second, MaxRequests: 3},
+		Paths:      []string{"^/wp-admin", `/\.env$`},
+		UserAgents: []string{"(?i)sqlmap"},
+	})
+	if err != nil {
+		t.Fatalf("unable to create engine: %v", err)
+	}
+	hits := []internaldb.CollectionData{
+		{ID: 1, IP: "192.0.2.1", FrontendName: "a", Path: "/", TimeDate: 100},
+		{ID: 2, IP: "192.0.2.2", FrontendName: "a", Path: "/app/.env", TimeDate: 100},
+		{ID: 3, IP: "192.0.2.1", FrontendName: "a", Path: "/about", TimeDate: 200},
+		{ID: 4, IP: "192.0.2.3", FrontendName: "b", Path: "/", UserAgent: "SQLMap/1.7", TimeDate: 100},
+		// four requests within ten seconds
+		{ID: 5, IP: "192.0.2.4", FrontendName: "b", Path: "/", TimeDate: 100},
+		{ID: 6, IP: "192.0.2.4", FrontendName: "b", Path: "/", TimeDate: 104},
+		{ID: 7, IP: "192.0.2.4", FrontendName: "b", Path: "/", TimeDate: 107},
+		{ID: 8, IP: "192.0.2.4", FrontendName: "b", Path: "/", TimeDate: 109},
+		// four requests, but never more than three within ten seconds
+		{ID: 9, IP: "192.0.2.5", FrontendName: "b", Path: "/", TimeDate: 100},
+		{ID: 10, IP: "192.0.2.5", FrontendName: "b", Path: "/", TimeDate: 105},
+		{ID: 11, IP: "192.0.2.5", FrontendName: "b", Path: "/", TimeDate: 109},
+		{ID: 12, IP: "192.0.2.5", FrontendName: "b", Path: "/", TimeDate: 110},
+	}
+
+	verdicts := engine.Evaluate(hits)
+
+	expected := map[string]int{"192.0.2.2": 1, "192.0.2.3": 1, "192.0.2.4": 4}
+	if len(verdicts) != len(expected) {
+		t.Fatalf("expected %d verdicts, got %+v", len(expected), verdicts)
+	}
+	for _, verdict := range verdicts {
+		ids, ok := expected[verdict.IP]
+		if !ok {
+			t.Errorf("unexpected verdict for %s: %s", verdict.IP, verdict.Reason)
+			continue
+		}
+		if len(verdict.IDs) != ids {
+			t.Errorf("expected %d rows flagged for %s, got %v", ids, verdict.IP, verdict.IDs)
+		}
+		if verdict.Reason == "" {
+			t.Errorf("expected a reason for %s", verdict.IP)
+		}
+	}
+}
+
+// TestLoadConfig Checks that both YAML and JSON rules files are understood
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"rules.yaml": "interval: 30s\nbanDuration: 24h\nrateLimit:\n  window: 1m\n  maxRequests: 100\npaths:\n  - \"^/wp-admin\"\n",
+		"rules.json": `{"interval": "30s", "banDuration": "24h", "rateLimit": {"window": "1m", "maxRequests": 100}, "paths": ["^/wp-admin"]}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := rules.LoadConfig(path)
+		if err != nil {
+			t.Errorf("%s: unable to load config: %v", name, err)
+			continue
+		}
+		if config.Interval != 30*time.Second || config.BanDuration != 24*time.Hour ||
+			config.RateLimit.Window != time.Minute || config.RateLimit.MaxRequests != 100 || len(config.Paths) != 1 {
+			t.Errorf("%s: unexpected config %+v", name, config)
+		}
+	}
+}
+
+// TestNewInvalidPatterns Checks that every invalid pattern is reported
+func TestNewInvalidPatterns(t *testing.T) {
+	_, err := rules.New(rules.Config{Paths: []string{"("}, UserAgents: []string{"["}})
+	if err == nil {
+		t.Fatal("expected an error for invalid patterns")
+	}
+}
+
+// TestEvaluateRateLimit Checks hits sharing a timestamp, and that request rates are counted across batches until an IP goes quiet
+func TestEvaluateRateLimit(t *testing.T) {
+	_, err := rules.New(rules.Config{RateLimit: rules.RateLimit{Window: 500 * time.Millisecond, MaxRequests: 1}})
+	if err == nil {
+		t.Error("expected windows under a second to be refused, rows are timed in seconds")
+	}
+
+	engine, err := rules.New(rules.Config{RateLimit: rules.RateLimit{Window: time.Second, MaxRequests: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verdicts := engine.Evaluate([]internaldb.CollectionData{{ID: 1, IP: "192.0.2.1", TimeDate: 100}, {ID: 2, IP: "192.0.2.1", TimeDate: 100}})
+	if len(verdicts) != 1 {
+		t.Errorf("expected two hits within the same second to exceed one request per second, got %+v", verdicts)
+	}
+
+	engine, err = rules.New(rules.Config{RateLimit: rules.RateLimit{Window: 10 * time.Second, MaxRequests: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := []struct {
+		hits   []internaldb.CollectionData
+		banned []string
+	}{
+		{[]internaldb.CollectionData{{ID: 1, IP: "192.0.2.1", TimeDate: 100}, {ID: 2, IP: "192.0.2.2", TimeDate: 100}}, nil},
+		{[]internaldb.CollectionData{{ID: 3, IP: "192.0.2.1", TimeDate: 104}, {ID: 4, IP: "192.0.2.2", TimeDate: 104}}, nil},
+		// 192.0.2.1 sends its third request within ten seconds, 192.0.2.2's first request has left the window
+		{[]internaldb.CollectionData{{ID: 5, IP: "192.0.2.1", TimeDate: 108}, {ID: 6, IP: "192.0.2.2", TimeDate: 111}}, []string{"192.0.2.1"}},
+		// 192.0.2.1 was banned and starts over, 192.0.2.2 went quiet for longer than the window
+		{[]internaldb.CollectionData{{ID: 7, IP: "192.0.2.1", TimeDate: 109}, {ID: 8, IP: "192.0.2.2", TimeDate: 125}}, nil},
+	}
+	for i, batch := range batches {
+		var banned []string
+		for _, verdict := range engine.Evaluate(batch.hits) {
+			banned = append(banned, verdict.IP)
+			if len(verdict.IDs) != 1 {
+				t.Errorf("batch %d: expected only the batch's row to be flagged, got %v", i, verdict.IDs)
+			}
+		}
+		if !slices.Equal(banned, batch.banned) {
+			t.Errorf("batch %d: expected %v to be banned, got %v", i, batch.banned, banned)
+		}
+	}
+}